@@ -0,0 +1,142 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// Conditions and condition Reasons for the KinkControlPlane object.
+
+const (
+	// MachinesOwnedCondition reports that all the control plane KinkMachines of the cluster are
+	// controlled by the KinkControlPlane.
+	MachinesOwnedCondition clusterv1.ConditionType = "MachinesOwned"
+
+	// ForeignMachinesReason (Severity=Error) documents that some KinkMachines labeled as the
+	// control plane of the cluster are controlled by another object; they are neither counted
+	// as replicas nor removed.
+	ForeignMachinesReason = "ForeignMachines"
+)
+
+const (
+	// MachinesHealthyCondition reports that all the KinkMachines of the KinkControlPlane are
+	// healthy, or the remediation of the unhealthy ones.
+	MachinesHealthyCondition clusterv1.ConditionType = "MachinesHealthy"
+
+	// MachineUnhealthyReason (Severity=Warning) documents that a KinkMachine is unhealthy, and
+	// will be remediated after the UnhealthyTimeout.
+	MachineUnhealthyReason = "MachineUnhealthy"
+
+	// RemediatingReason (Severity=Warning) documents that an unhealthy KinkMachine is being
+	// replaced.
+	RemediatingReason = "Remediating"
+
+	// RemediationDeferredReason (Severity=Warning) documents that the remediation of an
+	// unhealthy KinkMachine waits for the backoff, the other remediation, the scaling or the
+	// rollout, or for another KinkMachine to be healthy.
+	RemediationDeferredReason = "RemediationDeferred"
+
+	// RemediationFailedReason (Severity=Error) documents that the unhealthy KinkMachines are not
+	// remediated any more, as the retries are exhausted.
+	RemediationFailedReason = "RemediationFailed"
+)
+
+const (
+	// CertificatesAvailableCondition reports that the CAs, the service account signing keys and
+	// the keys of encryption at rest of the cluster are available.
+	CertificatesAvailableCondition clusterv1.ConditionType = "CertificatesAvailable"
+
+	// CertificatesGenerationFailedReason (Severity=Warning) documents that the certificates or
+	// keys of the cluster failed to be generated or rotated.
+	CertificatesGenerationFailedReason = "CertificatesGenerationFailed"
+
+	// KubeconfigAvailableCondition reports that the kubeconfigs of the cluster and of the
+	// control plane components are available.
+	KubeconfigAvailableCondition clusterv1.ConditionType = "KubeconfigAvailable"
+
+	// KubeconfigGenerationFailedReason (Severity=Warning) documents that a kubeconfig failed to
+	// be generated.
+	KubeconfigGenerationFailedReason = "KubeconfigGenerationFailed"
+
+	// ResourcesAvailableCondition reports that the resources of the control plane in the host
+	// cluster are set up: the audit policy, the admission configuration, the NetworkPolicies,
+	// the PodDisruptionBudgets and the discovery documents of the service account issuer.
+	ResourcesAvailableCondition clusterv1.ConditionType = "ResourcesAvailable"
+
+	// ResourcesSetupFailedReason (Severity=Warning) documents that a resource of the control
+	// plane failed to be set up.
+	ResourcesSetupFailedReason = "ResourcesSetupFailed"
+)
+
+const (
+	// MachinesCreatedCondition reports that the KinkMachines match the replicas of the
+	// KinkControlPlane.
+	MachinesCreatedCondition clusterv1.ConditionType = "MachinesCreated"
+
+	// WaitingForClusterInfrastructureReason (Severity=Info) documents that no KinkMachine is
+	// created until the infrastructure and the control plane endpoint of the cluster are ready.
+	WaitingForClusterInfrastructureReason = "WaitingForClusterInfrastructure"
+
+	// ScalingUpReason (Severity=Info) documents that the KinkMachines are being created.
+	ScalingUpReason = "ScalingUp"
+
+	// ScalingDownReason (Severity=Info) documents that the KinkMachines are being removed.
+	ScalingDownReason = "ScalingDown"
+
+	// MachinesScalingFailedReason (Severity=Warning) documents that the KinkMachines failed to
+	// be created or removed.
+	MachinesScalingFailedReason = "MachinesScalingFailed"
+
+	// MachinesReadyCondition aggregates the Ready conditions of the KinkMachines.
+	MachinesReadyCondition clusterv1.ConditionType = "MachinesReady"
+
+	// MachinesUpToDateCondition reports that the control plane pods of all the KinkMachines are
+	// created from the current templates.
+	MachinesUpToDateCondition clusterv1.ConditionType = "MachinesUpToDate"
+
+	// RollingOutReason (Severity=Info) documents that the outdated KinkMachines are being
+	// rolled, one KinkMachine at a time.
+	RollingOutReason = "RollingOut"
+
+	// EtcdOutdatedReason (Severity=Warning) documents that the other pods of the KinkMachines are
+	// up to date, but the etcd pods of some are outdated; as etcd is never rolled, those
+	// KinkMachines have to be replaced, e.g. by scaling up and down.
+	EtcdOutdatedReason = "EtcdOutdated"
+)
+
+const (
+	// EtcdClusterHealthyCondition reports that the etcd members of all the KinkMachines are ready.
+	EtcdClusterHealthyCondition clusterv1.ConditionType = "EtcdClusterHealthy"
+
+	// WaitingForEtcdReason (Severity=Info) documents that the etcd members are bootstrapping.
+	WaitingForEtcdReason = "WaitingForEtcd"
+
+	// EtcdMembersUnhealthyReason (Severity=Warning) documents that some etcd members are not
+	// ready, but others are.
+	EtcdMembersUnhealthyReason = "EtcdMembersUnhealthy"
+
+	// EtcdUnavailableReason (Severity=Error) documents that no etcd member is ready.
+	EtcdUnavailableReason = "EtcdUnavailable"
+
+	// APIServerAvailableCondition reports that at least one API Server of the cluster is ready.
+	APIServerAvailableCondition clusterv1.ConditionType = "APIServerAvailable"
+
+	// APIServerUnavailableReason (Severity=Warning) documents that no API Server of the cluster
+	// is ready.
+	APIServerUnavailableReason = "APIServerUnavailable"
+)
@@ -17,11 +17,21 @@ limitations under the License.
 package v1beta1
 
 import (
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+// HostNetworkAnnotationName is "true" to keep the hardened control plane pods of KinkControlPlane
+// on the host network. It is set once by the controller: to "true" if the pods were created on
+// the host network, as the clients of the cluster may reach them by the addresses of the hosts,
+// or to "false" otherwise; set it to "false" to move the pods to the pod network. On the pod
+// network, the API Servers are reached through the Service of APIServerServiceType.
+const HostNetworkAnnotationName = "kink.openbce.io/host-network"
+
 // KinkControlPlaneSpec defines the desired state of KinkControlPlane
 type KinkControlPlaneSpec struct {
 	// Replicas is the replicas of control plane.
@@ -30,8 +40,665 @@ type KinkControlPlaneSpec struct {
 	// ClusterName is the name of cluster.
 	ClusterName string `json:"clusterName,omitempty"`
 
-	// Version is the version of kubernetes for the cluster.
+	// Version is the version of kubernetes for the cluster. The control plane pods of a
+	// KinkMachine run the images of the version it was created with; v1.24.1 if it is not valid.
 	Version *string `json:"version,omitempty"`
+
+	// CertSANs sets extra Subject Alternative Names (DNS names or IPs) for the API Server
+	// serving certificate, e.g. vanity hostnames or the pod IPs when host networking is used.
+	// Changing the list re-issues the certificate and rolls the API Server pods.
+	// +optional
+	CertSANs []string `json:"certSANs,omitempty"`
+
+	// APIServerServiceType is the type of the Service of the API Servers; defaults to ClusterIP.
+	// The hardened control plane pods run on the pod network, so the control plane endpoint of
+	// the cluster has to reach the API Servers through this Service, e.g. by the address of the
+	// LoadBalancer or a NodePort. The DNS names of the Service are in the serving certificate.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	APIServerServiceType v1.ServiceType `json:"apiServerServiceType,omitempty"`
+
+	// ServiceAccount configures the service account tokens of the cluster.
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+
+	// Authentication configures how the users are authenticated by the API Server, in addition
+	// to the client certificates.
+	// +optional
+	Authentication *AuthenticationSpec `json:"authentication,omitempty"`
+
+	// Authorization configures how the requests are authorized by the API Server, in addition
+	// to the Node and RBAC authorizers.
+	// +optional
+	Authorization *AuthorizationSpec `json:"authorization,omitempty"`
+
+	// Audit configures the audit policy and the audit backends of the API Server. The requests
+	// are not audited if it is not set.
+	// +optional
+	Audit *AuditSpec `json:"audit,omitempty"`
+
+	// Encryption configures the encryption at rest of the resources in etcd. Removing it does
+	// not decrypt the resources already encrypted, the keys are kept to read them.
+	// +optional
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+
+	// PodSecurity configures the security context of the control plane pods. The pods are
+	// hardened to pass the restricted Pod Security Standard by default.
+	// +optional
+	PodSecurity *PodSecuritySpec `json:"podSecurity,omitempty"`
+
+	// SecurityProfile hardens the flags of the control plane components; the flags that are
+	// not supported by the Kubernetes version of the machines are not set.
+	// +optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// NetworkPolicy configures the NetworkPolicies isolating the control plane pods, which are
+	// created by default. They have no effect on the privileged pods on the host network.
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// Scheduling places the control plane pods of all the KinkMachines in the host cluster.
+	// +optional
+	Scheduling *SchedulingSpec `json:"scheduling,omitempty"`
+
+	// Size is the preset of the resources of the control plane components; defaults to small.
+	// The Resources of a component override the preset.
+	// +kubebuilder:validation:Enum=small;medium;large
+	// +optional
+	Size ControlPlaneSize `json:"size,omitempty"`
+
+	// PriorityClassName is the PriorityClass of the control plane pods, e.g. the
+	// kink-control-plane PriorityClass installed with kink, so they are not preempted by the
+	// ordinary workloads of the host cluster.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// APIServer customizes the API Server.
+	// +optional
+	APIServer *ControlPlaneComponent `json:"apiServer,omitempty"`
+
+	// ControllerManager customizes the controller manager.
+	// +optional
+	ControllerManager *ControlPlaneComponent `json:"controllerManager,omitempty"`
+
+	// Scheduler customizes the scheduler.
+	// +optional
+	Scheduler *ControlPlaneComponent `json:"scheduler,omitempty"`
+
+	// Etcd customizes etcd, which has no feature gates.
+	// +optional
+	Etcd *ControlPlaneComponent `json:"etcd,omitempty"`
+
+	// Remediation configures how the unhealthy KinkMachines are replaced.
+	// +optional
+	Remediation *RemediationSpec `json:"remediation,omitempty"`
+}
+
+// RemediationSpec defines when the unhealthy KinkMachines are replaced. A KinkMachine is
+// unhealthy if any of its control plane pods is not ready, e.g. crash-looping or a failed etcd
+// member, for longer than the UnhealthyTimeout; the KinkMachines annotated with
+// cluster.x-k8s.io/skip-remediation are not remediated.
+type RemediationSpec struct {
+	// Disabled disables the remediation of the KinkMachines.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// UnhealthyTimeout is how long a KinkMachine stays unhealthy before it is replaced; defaults
+	// to 5m.
+	// +optional
+	UnhealthyTimeout *metav1.Duration `json:"unhealthyTimeout,omitempty"`
+
+	// MaxRetries is the maximum number of the consecutive remediations, which are retried with
+	// an exponential backoff; defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+
+	// RetryPeriod is the backoff before the first retry, which is doubled for each retry up to
+	// 1h; defaults to 1m.
+	// +optional
+	RetryPeriod *metav1.Duration `json:"retryPeriod,omitempty"`
+
+	// MinHealthyPeriod is how long the KinkMachines stay healthy after a remediation before the
+	// retries are reset; defaults to 1h.
+	// +optional
+	MinHealthyPeriod *metav1.Duration `json:"minHealthyPeriod,omitempty"`
+}
+
+// ControlPlaneSize is a preset of the resources of the control plane components.
+type ControlPlaneSize string
+
+const (
+	SmallControlPlane  ControlPlaneSize = "small"
+	MediumControlPlane ControlPlaneSize = "medium"
+	LargeControlPlane  ControlPlaneSize = "large"
+)
+
+// SchedulingSpec defines how the control plane pods are scheduled in the host cluster.
+type SchedulingSpec struct {
+	// NodeSelector selects the nodes that the pods can run on.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations are the tolerations of the pods.
+	// +optional
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity is the affinity of the pods. Unless PodAntiAffinity is set, the pods of the same
+	// role in the cluster prefer different nodes and zones.
+	// +optional
+	Affinity *v1.Affinity `json:"affinity,omitempty"`
+
+	// TopologySpreadConstraints are the topology spread constraints of the pods; a constraint
+	// without LabelSelector selects the pods of the same role in the cluster.
+	// +optional
+	TopologySpreadConstraints []v1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+// ControlPlaneComponent customizes a control plane component, in the style of kubeadm's
+// ClusterConfiguration.
+type ControlPlaneComponent struct {
+	// ExtraArgs are the extra flags of the component, keyed by the flag names without the
+	// leading dashes. They replace the flags set by kink with the same names; the flags that
+	// kink must control, e.g. the certificates, kubeconfigs and listening addresses, are rejected.
+	// +optional
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+
+	// ExtraEnv are the extra environment variables of the component container.
+	// +optional
+	ExtraEnv []v1.EnvVar `json:"extraEnv,omitempty"`
+
+	// ExtraVolumes are the extra volumes of the component pods.
+	// +optional
+	ExtraVolumes []v1.Volume `json:"extraVolumes,omitempty"`
+
+	// ExtraVolumeMounts are the extra volume mounts of the component container.
+	// +optional
+	ExtraVolumeMounts []v1.VolumeMount `json:"extraVolumeMounts,omitempty"`
+
+	// FeatureGates are the feature gates of the component.
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+	// Resources are the resources of the component container, which override the requests and
+	// limits of the same resources in the Size preset.
+	// +optional
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+
+	// PodTemplate is a partial PodTemplateSpec, which is applied as a strategic merge patch to
+	// the pods of the component, e.g. for sidecars, init containers and extra labels or
+	// annotations. The names, namespaces, owners and the labels and annotations of kink can not
+	// be overridden, and the directives of strategic merge patch, e.g. "$patch", are not
+	// accepted. The containers are still hardened unless the pods are privileged.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +optional
+	PodTemplate *runtime.RawExtension `json:"podTemplate,omitempty"`
+}
+
+// NetworkPolicySpec defines the traffic allowed to the control plane pods. Etcd only accepts
+// the traffic from the API Server and its peers of the same cluster, and the controller manager
+// and the scheduler only accept the traffic to their metrics ports.
+type NetworkPolicySpec struct {
+	// Disabled removes the NetworkPolicies of the control plane pods.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// AllowedCIDRs are the CIDRs allowed to access the API Server. The API Server is accessible
+	// from anywhere if neither AllowedCIDRs nor IngressFrom is set.
+	// +optional
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+
+	// IngressFrom are the peers in the host cluster allowed to access the API Server, e.g. the
+	// ingress controllers and the manager of kink. The control plane pods of the same cluster
+	// are always allowed.
+	// +optional
+	IngressFrom []networkingv1.NetworkPolicyPeer `json:"ingressFrom,omitempty"`
+
+	// MetricsFrom are the peers allowed to scrape the metrics of the controller manager and the
+	// scheduler; defaults to anywhere.
+	// +optional
+	MetricsFrom []networkingv1.NetworkPolicyPeer `json:"metricsFrom,omitempty"`
+}
+
+// SecurityProfile is a named set of hardening flags of the control plane components.
+// +kubebuilder:validation:Enum=baseline;cis
+type SecurityProfile string
+
+const (
+	// BaselineSecurityProfile disables profiling, and only allows TLS 1.2 or later with strong
+	// cipher suites.
+	BaselineSecurityProfile SecurityProfile = "baseline"
+
+	// CISSecurityProfile extends the baseline by the CIS Kubernetes Benchmark: it disables the
+	// anonymous requests, enables the PodSecurity and EventRateLimit admission plugins, and
+	// verifies the serving certificates of the kubelets by the cluster CA. The anonymous
+	// discovery of service account issuer from the API Server is not available. The kubelets
+	// with self-signed serving certificates fail kubectl logs and exec, unless the
+	// kubelet-certificate-authority flag is overridden by the extraArgs of the API Server, e.g.
+	// by the file of another CA, or by "" to skip the verification.
+	CISSecurityProfile SecurityProfile = "cis"
+)
+
+// PodSecuritySpec defines the security context of the control plane pods.
+type PodSecuritySpec struct {
+	// Privileged opts out of the hardening: the pods run on the host network with the defaults
+	// of the images, e.g. as root with a writable root filesystem.
+	// +optional
+	Privileged bool `json:"privileged,omitempty"`
+
+	// RunAsUser is the user that the containers run as; defaults to 65532.
+	// +optional
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+
+	// RunAsGroup is the group that the containers run as, and that owns the files of the
+	// volumes; defaults to 65532.
+	// +optional
+	RunAsGroup *int64 `json:"runAsGroup,omitempty"`
+}
+
+// EncryptionProvider is the provider to encrypt the resources with.
+type EncryptionProvider string
+
+const (
+	// AESCBCEncryption encrypts the resources by AES-CBC with PKCS#7 padding.
+	AESCBCEncryption EncryptionProvider = "aescbc"
+
+	// SecretboxEncryption encrypts the resources by XSalsa20 and Poly1305.
+	SecretboxEncryption EncryptionProvider = "secretbox"
+
+	// KMSEncryption encrypts the resources by the envelope encryption with a KMS plugin.
+	KMSEncryption EncryptionProvider = "kms"
+
+	// IdentityEncryption does not encrypt the resources; it stands for the resources written in
+	// plaintext in the state of the encryption.
+	IdentityEncryption EncryptionProvider = "identity"
+)
+
+// EncryptionSpec defines how the resources are encrypted at rest.
+type EncryptionSpec struct {
+	// Provider is the provider to encrypt the resources with; defaults to aescbc. Changing it
+	// rotates the key.
+	// +kubebuilder:validation:Enum=aescbc;secretbox;kms
+	// +optional
+	Provider EncryptionProvider `json:"provider,omitempty"`
+
+	// KMS is the KMS plugin of the kms provider. It has to be kept until the keys of the plugin
+	// are rotated away, as the resources are decrypted by it.
+	// +optional
+	KMS *KMSPlugin `json:"kms,omitempty"`
+
+	// Resources are the resources to encrypt, e.g. "secrets" or "deployments.apps"; defaults to
+	// secrets. The resources can not be removed once they are encrypted.
+	// +optional
+	Resources []string `json:"resources,omitempty"`
+
+	// KeyRotationPeriod is how long a key is used before a new one is introduced. The key is
+	// never rotated if it is not set; the kms provider leaves the rotation to the KMS.
+	// +optional
+	KeyRotationPeriod *metav1.Duration `json:"keyRotationPeriod,omitempty"`
+}
+
+// KMSAPIVersion is the version of the KMS API served by a KMS plugin.
+type KMSAPIVersion string
+
+const (
+	// KMSAPIVersionV1 is the KMS v1 API (v1beta1.KeyManagementService).
+	KMSAPIVersionV1 KMSAPIVersion = "v1"
+
+	// KMSAPIVersionV2 is the KMS v2 API (v2alpha1.KeyManagementService), which is supported by
+	// the API Server since v1.25; it is behind the KMSv2 feature gate of the API Server before
+	// v1.27.
+	KMSAPIVersionV2 KMSAPIVersion = "v2"
+)
+
+// KMSPlugin defines the KMS plugin running as a sidecar container of the API Server. The plugin
+// has to serve the KMS API of APIVersion on the Unix socket in the KMS_SOCKET_PATH environment
+// variable, e.g. by passing "--listen=unix://$(KMS_SOCKET_PATH)".
+type KMSPlugin struct {
+	// Name is the name of the plugin, as recorded in the prefix of the encrypted resources.
+	// Changing it rotates the key.
+	Name string `json:"name"`
+
+	// APIVersion is the version of the KMS API served by the plugin; defaults to v1. It is
+	// recorded with the key, so changing it takes effect with a new Name. v2 requires the
+	// KinkControlPlane and all its KinkMachines to be on v1.25 or later.
+	// +kubebuilder:validation:Enum=v1;v2
+	// +optional
+	APIVersion KMSAPIVersion `json:"apiVersion,omitempty"`
+
+	// Image is the image of the plugin. It is ignored if Container is set.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Args are the arguments of the plugin.
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// Env are the environment variables of the plugin, e.g. the credentials of the external KMS.
+	// +optional
+	Env []v1.EnvVar `json:"env,omitempty"`
+
+	// Config is mounted into the plugin at /etc/kms-plugin, e.g. a Secret with its configuration.
+	// +optional
+	Config *v1.VolumeSource `json:"config,omitempty"`
+
+	// Container is the sidecar container of the plugin, for the plugins that need more than the
+	// image and arguments; the socket volume is mounted into it.
+	// +optional
+	Container *v1.Container `json:"container,omitempty"`
+}
+
+// EncryptionKeyState is the state of an encryption key in the rotation.
+type EncryptionKeyState string
+
+const (
+	// EncryptionKeyPending is a new key that can only decrypt, until all the API Servers know it.
+	EncryptionKeyPending EncryptionKeyState = "Pending"
+
+	// EncryptionKeyActive is the key to encrypt the resources.
+	EncryptionKeyActive EncryptionKeyState = "Active"
+
+	// EncryptionKeyRetired is a replaced key, which is removed once all the resources are
+	// re-encrypted by the active key.
+	EncryptionKeyRetired EncryptionKeyState = "Retired"
+)
+
+// EncryptionKey describes a key of the encryption at rest.
+type EncryptionKey struct {
+	// Name is the name of the key, as recorded in the prefix of the encrypted resources.
+	Name string `json:"name"`
+
+	// Provider is the provider of the key.
+	Provider EncryptionProvider `json:"provider"`
+
+	// State is the state of the key in the rotation.
+	State EncryptionKeyState `json:"state"`
+
+	// CreatedAt is when the key was introduced.
+	CreatedAt metav1.Time `json:"createdAt"`
+
+	// KMSAPIVersion is the version of the KMS API of the key of the kms provider.
+	// +optional
+	KMSAPIVersion KMSAPIVersion `json:"kmsAPIVersion,omitempty"`
+}
+
+// EncryptionStatus describes the encryption at rest of the resources.
+type EncryptionStatus struct {
+	// Resources are the encrypted resources.
+	Resources []string `json:"resources,omitempty"`
+
+	// PendingResources are the resources to encrypt, until all the API Servers know them.
+	PendingResources []string `json:"pendingResources,omitempty"`
+
+	// Keys are the keys in the encryption configuration of the API Servers.
+	Keys []EncryptionKey `json:"keys,omitempty"`
+}
+
+// AuditSpec defines the audit policy and the audit backends of the API Server.
+type AuditSpec struct {
+	// Policy is an inline audit Policy (audit.k8s.io/v1). It is mutually exclusive with PolicyRef;
+	// all the requests are audited at the Metadata level if neither is set.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Policy *runtime.RawExtension `json:"policy,omitempty"`
+
+	// PolicyRef is the key of a ConfigMap in the namespace of KinkControlPlane with the audit
+	// Policy. The API Server pods are rolled when the ConfigMap is changed.
+	// +optional
+	PolicyRef *v1.ConfigMapKeySelector `json:"policyRef,omitempty"`
+
+	// Log writes the audit events into a log file on a volume.
+	// +optional
+	Log *AuditLogBackend `json:"log,omitempty"`
+
+	// Webhook sends the audit events to a remote service.
+	// +optional
+	Webhook *AuditWebhookBackend `json:"webhook,omitempty"`
+
+	// Stdout ships the audit log file to the stdout of a sidecar container, so the audit events
+	// are collected with the logs of the pods; a log file is written on an emptyDir if Log is
+	// not set.
+	// +optional
+	Stdout *AuditStdoutBackend `json:"stdout,omitempty"`
+}
+
+// AuditLogFormat is the format of the audit log file.
+// +kubebuilder:validation:Enum=json;legacy
+type AuditLogFormat string
+
+// AuditLogBackend defines the audit log file and its rotation.
+type AuditLogBackend struct {
+	// Volume is where the audit log file is written; defaults to an emptyDir.
+	// +optional
+	Volume *v1.VolumeSource `json:"volume,omitempty"`
+
+	// MaxAge is the maximum number of days to retain the rotated log files.
+	// +optional
+	MaxAge *int32 `json:"maxAge,omitempty"`
+
+	// MaxBackup is the maximum number of the rotated log files to retain.
+	// +optional
+	MaxBackup *int32 `json:"maxBackup,omitempty"`
+
+	// MaxSize is the maximum size in megabytes of the log file before it is rotated.
+	// +optional
+	MaxSize *int32 `json:"maxSize,omitempty"`
+
+	// Compress compresses the rotated log files with gzip.
+	// +optional
+	Compress bool `json:"compress,omitempty"`
+
+	// Format is the format of the log file; defaults to json.
+	// +optional
+	Format AuditLogFormat `json:"format,omitempty"`
+}
+
+// AuditWebhookMode is how the audit events are sent to the webhook.
+// +kubebuilder:validation:Enum=batch;blocking;blocking-strict
+type AuditWebhookMode string
+
+// AuditWebhookBackend defines the remote service to receive the audit events.
+type AuditWebhookBackend struct {
+	// Kubeconfig is the key of a Secret in the namespace of KinkControlPlane with the kubeconfig
+	// to access the remote service.
+	Kubeconfig v1.SecretKeySelector `json:"kubeconfig"`
+
+	// Mode is how the audit events are sent; defaults to batch.
+	// +optional
+	Mode AuditWebhookMode `json:"mode,omitempty"`
+
+	// InitialBackoff is how long to wait before retrying the first failed request; defaults to 10s.
+	// +optional
+	InitialBackoff *metav1.Duration `json:"initialBackoff,omitempty"`
+}
+
+// AuditStdoutBackend defines the sidecar container shipping the audit log to stdout.
+type AuditStdoutBackend struct {
+	// Image is the image of the sidecar container, which must provide the tail command;
+	// defaults to busybox.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// AuthenticationSpec defines the authenticators of the API Server.
+type AuthenticationSpec struct {
+	// OIDC authenticates the users by the ID tokens of an OpenID Connect provider.
+	// +optional
+	OIDC *OIDCAuthentication `json:"oidc,omitempty"`
+
+	// Webhook authenticates the bearer tokens by a remote service with TokenReview.
+	// +optional
+	Webhook *WebhookAuthentication `json:"webhook,omitempty"`
+}
+
+// AuthorizationSpec defines the authorizers of the API Server.
+type AuthorizationSpec struct {
+	// Webhook authorizes the requests by a remote service with SubjectAccessReview; it is
+	// consulted after the Node and RBAC authorizers, so the components of the cluster keep
+	// working when the remote service is not available.
+	// +optional
+	Webhook *WebhookAuthorization `json:"webhook,omitempty"`
+}
+
+// WebhookVersion is the API version of the review objects sent to the webhook.
+// +kubebuilder:validation:Enum=v1;v1beta1
+type WebhookVersion string
+
+// WebhookAuthentication defines the remote service to authenticate the bearer tokens.
+type WebhookAuthentication struct {
+	// Kubeconfig is the key of a Secret in the namespace of KinkControlPlane with the kubeconfig
+	// to access the remote service.
+	Kubeconfig v1.SecretKeySelector `json:"kubeconfig"`
+
+	// CacheTTL is how long the responses of the remote service are cached; defaults to 2m.
+	// +optional
+	CacheTTL *metav1.Duration `json:"cacheTTL,omitempty"`
+
+	// Version is the API version of TokenReview sent to the remote service; defaults to v1beta1.
+	// +optional
+	Version WebhookVersion `json:"version,omitempty"`
+}
+
+// WebhookAuthorization defines the remote service to authorize the requests.
+type WebhookAuthorization struct {
+	// Kubeconfig is the key of a Secret in the namespace of KinkControlPlane with the kubeconfig
+	// to access the remote service.
+	Kubeconfig v1.SecretKeySelector `json:"kubeconfig"`
+
+	// AuthorizedTTL is how long the authorized responses are cached; defaults to 5m.
+	// +optional
+	AuthorizedTTL *metav1.Duration `json:"authorizedTTL,omitempty"`
+
+	// UnauthorizedTTL is how long the unauthorized responses are cached; defaults to 30s.
+	// +optional
+	UnauthorizedTTL *metav1.Duration `json:"unauthorizedTTL,omitempty"`
+
+	// Version is the API version of SubjectAccessReview sent to the remote service; defaults
+	// to v1beta1.
+	// +optional
+	Version WebhookVersion `json:"version,omitempty"`
+}
+
+// OIDCAuthentication defines the OpenID Connect provider to authenticate the users.
+type OIDCAuthentication struct {
+	// IssuerURL is the URL of the provider, only the https scheme is accepted.
+	IssuerURL string `json:"issuerURL"`
+
+	// ClientID is the client ID that all the tokens must be issued for.
+	ClientID string `json:"clientID"`
+
+	// UsernameClaim is the claim used as the user name; defaults to "sub".
+	// +optional
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+
+	// UsernamePrefix is prepended to the user names to prevent clashes with other strategies.
+	// +optional
+	UsernamePrefix string `json:"usernamePrefix,omitempty"`
+
+	// GroupsClaim is the claim used as the groups of the user.
+	// +optional
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+
+	// GroupsPrefix is prepended to the group names to prevent clashes with other strategies.
+	// +optional
+	GroupsPrefix string `json:"groupsPrefix,omitempty"`
+
+	// RequiredClaims are the claims that must be present in the ID token with the given values.
+	// +optional
+	RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+
+	// SigningAlgs are the accepted signing algorithms; defaults to RS256.
+	// +optional
+	SigningAlgs []string `json:"signingAlgs,omitempty"`
+
+	// CABundle is the key of a ConfigMap in the namespace of KinkControlPlane with the CA
+	// certificates of the provider; the host's root CAs are used if it is not set.
+	// +optional
+	CABundle *v1.ConfigMapKeySelector `json:"caBundle,omitempty"`
+}
+
+// ServiceAccountSpec defines how the service account tokens are signed and verified.
+type ServiceAccountSpec struct {
+	// KeyRotationPeriod is how long a signing key is used before a new one is introduced; the
+	// new key signs the tokens once all the API Servers verify them. The signing key is never
+	// rotated if it is not set.
+	// +optional
+	KeyRotationPeriod *metav1.Duration `json:"keyRotationPeriod,omitempty"`
+
+	// MaxTokenExpiration is the maximum lifetime of the service account tokens; a retired
+	// signing key is kept to verify tokens for this long before it is pruned. Defaults to 24h.
+	// +optional
+	MaxTokenExpiration *metav1.Duration `json:"maxTokenExpiration,omitempty"`
+
+	// Issuer is the identifier of the service account token issuer, e.g. an external URL for
+	// workload identity federation. Defaults to https://kubernetes.default.svc.<service domain>.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+
+	// JWKSURI overrides the URI of the JSON Web Key Set in the discovery document of the issuer.
+	// +optional
+	JWKSURI string `json:"jwksURI,omitempty"`
+
+	// Discovery exposes the OpenID Connect discovery document and the JSON Web Key Set of the
+	// issuer publicly. They are not exposed if it is not set.
+	// +optional
+	Discovery *ServiceAccountDiscovery `json:"discovery,omitempty"`
+}
+
+// ServiceAccountDiscoveryMode is how the discovery documents of the issuer are exposed.
+type ServiceAccountDiscoveryMode string
+
+const (
+	// APIServerDiscovery allows anonymous access to the discovery endpoints of the API Server
+	// through the control plane endpoint.
+	APIServerDiscovery ServiceAccountDiscoveryMode = "APIServer"
+
+	// ConfigMapDiscovery serves the discovery documents from a ConfigMap by a static web server;
+	// the issuer is expected to be the URL that the static web server is exposed at.
+	ConfigMapDiscovery ServiceAccountDiscoveryMode = "ConfigMap"
+)
+
+// ServiceAccountDiscovery defines how the discovery documents of the issuer are exposed.
+type ServiceAccountDiscovery struct {
+	// Mode is how the discovery documents are exposed.
+	// +kubebuilder:validation:Enum=APIServer;ConfigMap
+	Mode ServiceAccountDiscoveryMode `json:"mode"`
+
+	// Image is the image of the static web server in ConfigMap mode.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// ServiceAccountKey describes a service account signing key in the verification set.
+type ServiceAccountKey struct {
+	// KeyID is the ID of the key, as set in the "kid" header of the tokens signed by it.
+	KeyID string `json:"keyID"`
+
+	// CreatedAt is when the key was introduced.
+	CreatedAt metav1.Time `json:"createdAt"`
+
+	// RetiredAt is when the key stopped signing tokens; it is not set for the active key.
+	// +optional
+	RetiredAt *metav1.Time `json:"retiredAt,omitempty"`
+
+	// Pending is true while the new key is only in the verification set, until all the API
+	// Servers verify the tokens signed by it; it signs the tokens after that.
+	// +optional
+	Pending bool `json:"pending,omitempty"`
+}
+
+// ServiceAccountIssuer describes an issuer of the service account tokens accepted by the API
+// Servers.
+type ServiceAccountIssuer struct {
+	// Issuer is the identifier of the issuer.
+	Issuer string `json:"issuer"`
+
+	// RetiredAt is when the issuer stopped issuing tokens; it is not set for the current issuer.
+	// +optional
+	RetiredAt *metav1.Time `json:"retiredAt,omitempty"`
 }
 
 // KinkControlPlaneStatus defines the observed state of KinkControlPlane
@@ -83,11 +750,48 @@ type KinkControlPlaneStatus struct {
 	// +optional
 	FailureMessage *string `json:"failureMessage,omitempty"`
 
+	// ServiceAccountKeys are the service account signing keys in the verification set,
+	// the last one is the active signing key.
+	// +optional
+	ServiceAccountKeys []ServiceAccountKey `json:"serviceAccountKeys,omitempty"`
+
+	// ServiceAccountIssuers are the service account token issuers accepted by the API Servers,
+	// the last one issues the tokens; the previous ones are accepted until the tokens issued by
+	// them are expired.
+	// +optional
+	ServiceAccountIssuers []ServiceAccountIssuer `json:"serviceAccountIssuers,omitempty"`
+
+	// Encryption is the state of the encryption at rest of the resources.
+	// +optional
+	Encryption *EncryptionStatus `json:"encryption,omitempty"`
+
+	// AuditPolicyHash is the hash of the audit policy referenced by the policyRef of the audit,
+	// so the API Servers are rolled when the referenced ConfigMap is changed.
+	// +optional
+	AuditPolicyHash string `json:"auditPolicyHash,omitempty"`
+
+	// LastRemediation is the last remediation of the KinkMachines.
+	// +optional
+	LastRemediation *RemediationStatus `json:"lastRemediation,omitempty"`
+
 	// Conditions defines current service state of the KinkControlPlane.
 	// +optional
 	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
 }
 
+// RemediationStatus records a remediation of the KinkMachines.
+type RemediationStatus struct {
+	// Machine is the name of the remediated KinkMachine; it is cleared when the remediation is
+	// aborted, as the KinkMachine recovered before it was deleted.
+	Machine string `json:"machine"`
+
+	// Timestamp is when the remediation started.
+	Timestamp metav1.Time `json:"timestamp"`
+
+	// RetryCount is the number of the consecutive remediations before this one.
+	RetryCount int32 `json:"retryCount"`
+}
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:storageversion
@@ -101,6 +805,16 @@ type KinkControlPlane struct {
 	Status KinkControlPlaneStatus `json:"status,omitempty"`
 }
 
+// GetConditions returns the set of conditions for this object.
+func (kcp *KinkControlPlane) GetConditions() clusterv1.Conditions {
+	return kcp.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (kcp *KinkControlPlane) SetConditions(conditions clusterv1.Conditions) {
+	kcp.Status.Conditions = conditions
+}
+
 //+kubebuilder:object:root=true
 //+kubebuilder:storageversion
 
@@ -0,0 +1,84 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// Conditions and condition Reasons for the KinkCluster object.
+
+const (
+	// EndpointReadyCondition reports that the control plane endpoint of the cluster is set.
+	EndpointReadyCondition clusterv1.ConditionType = "EndpointReady"
+
+	// WaitingForControlPlaneEndpointReason (Severity=Info) documents that neither the KinkCluster
+	// nor its Cluster has the control plane endpoint yet.
+	WaitingForControlPlaneEndpointReason = "WaitingForControlPlaneEndpoint"
+)
+
+// Conditions and condition Reasons for the KinkMachine object; each role of the control plane
+// pods has its own condition, which records the bootstrap of the machine.
+
+const (
+	// EtcdReadyCondition reports that the etcd pod of the KinkMachine is ready.
+	EtcdReadyCondition clusterv1.ConditionType = "EtcdReady"
+
+	// APIServerReadyCondition reports that the API Server pod of the KinkMachine is ready.
+	APIServerReadyCondition clusterv1.ConditionType = "APIServerReady"
+
+	// ControllerManagerReadyCondition reports that the controller manager pod of the KinkMachine
+	// is ready.
+	ControllerManagerReadyCondition clusterv1.ConditionType = "ControllerManagerReady"
+
+	// SchedulerReadyCondition reports that the scheduler pod of the KinkMachine is ready.
+	SchedulerReadyCondition clusterv1.ConditionType = "SchedulerReady"
+
+	// PodsUpToDateCondition reports that the control plane pods of the KinkMachine, except etcd,
+	// are created from the current templates. The outdated pods are rolled once KinkControlPlane
+	// allows it.
+	PodsUpToDateCondition clusterv1.ConditionType = "PodsUpToDate"
+
+	// EtcdUpToDateCondition reports that the etcd pod of the KinkMachine is created from the
+	// current template. The etcd pod is never rolled, as it keeps its data in an emptyDir, so the
+	// changes of etcd take effect only when the KinkMachine is replaced.
+	EtcdUpToDateCondition clusterv1.ConditionType = "EtcdUpToDate"
+)
+
+const (
+	// WaitingForDependenciesReason (Severity=Info) documents that the pod of a role is not
+	// created or rolled, until the roles it depends on are ready.
+	WaitingForDependenciesReason = "WaitingForDependencies"
+
+	// PodCreatingReason (Severity=Info) documents that the pod of a role is being created.
+	PodCreatingReason = "PodCreating"
+
+	// PodRollingReason (Severity=Info) documents that the outdated pod of a role is being
+	// replaced.
+	PodRollingReason = "PodRolling"
+
+	// PodNotReadyReason (Severity=Warning) documents that the pod of a role is not ready.
+	PodNotReadyReason = "PodNotReady"
+
+	// PodsOutdatedReason (Severity=Info) documents that some pods are outdated, and wait for
+	// KinkControlPlane to allow the rollout of the KinkMachine.
+	PodsOutdatedReason = "PodsOutdated"
+
+	// EtcdOutdatedReason (Severity=Warning) documents that the etcd pod is outdated, and the
+	// KinkMachine has to be replaced to update it.
+	EtcdOutdatedReason = "EtcdOutdated"
+)
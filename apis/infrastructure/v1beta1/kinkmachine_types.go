@@ -28,6 +28,22 @@ type ControlPlaneRole string
 const (
 	ControlPlaneRoleLabelName = "kink.openbce.io/role"
 
+	// TemplateHashAnnotationName is the hash of the template that the control plane pod was
+	// created from; the pod is outdated when the template of its role was changed.
+	TemplateHashAnnotationName = "kink.openbce.io/template-hash"
+
+	// ServingLabelName selects the API Server pods into the endpoints of the API Server Service;
+	// it is set to false to drain the pod before it is deleted.
+	ServingLabelName = "kink.openbce.io/serving"
+
+	// DrainStartedAnnotationName records when the API Server pod was taken out of the endpoints;
+	// on a KinkMachine, it marks that the machine is drained to be removed.
+	DrainStartedAnnotationName = "kink.openbce.io/drain-started"
+
+	// RolloutAllowedAnnotationName is set by KinkControlPlane on the only KinkMachine allowed to
+	// roll its outdated pods, so the KinkMachines are rolled one at a time.
+	RolloutAllowedAnnotationName = "kink.openbce.io/rollout-allowed"
+
 	ApiServer         ControlPlaneRole = "apiserver"
 	Scheduler         ControlPlaneRole = "scheduler"
 	ControllerManager ControlPlaneRole = "controller-manager"
@@ -35,6 +51,15 @@ const (
 	Unkonwn           ControlPlaneRole = "unknown"
 )
 
+// ControlPlaneRoles are the roles of the control plane pods of a KinkMachine, in the order of
+// their dependencies.
+var ControlPlaneRoles = []ControlPlaneRole{
+	ETCD,
+	ApiServer,
+	ControllerManager,
+	Scheduler,
+}
+
 // KinkMachineSpec defines the desired state of KinkMachine
 type KinkMachineSpec struct {
 	// Version represents the minimum Kubernetes version for the control plane machines
@@ -83,6 +108,16 @@ type KinkMachine struct {
 	Status KinkMachineStatus `json:"status,omitempty"`
 }
 
+// GetConditions returns the set of conditions for this object.
+func (m *KinkMachine) GetConditions() clusterv1.Conditions {
+	return m.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (m *KinkMachine) SetConditions(conditions clusterv1.Conditions) {
+	m.Status.Conditions = conditions
+}
+
 //+kubebuilder:object:root=true
 //+kubebuilder:storageversion
 
@@ -0,0 +1,79 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// lookupOrSetupAdmissionConfig writes the admission configuration of the SecurityProfile of
+// KinkControlPlane into a ConfigMap, which is mounted into the API Server pods; the ConfigMap is
+// removed when the profile configures no admission plugins.
+func (r *KinkControlPlaneReconciler) lookupOrSetupAdmissionConfig(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	config, err := templates.BuildAdmissionConfig(kcp)
+	if err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      templates.AdmissionConfigConfigMapName(cluster),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	if config == nil {
+		if err := r.Get(ctx, types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		if err := r.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to remove the admission configuration")
+		}
+		return nil
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = map[string]string{
+			clusterv1.ClusterLabelName: cluster.Name,
+		}
+		cm.Data = map[string]string{
+			templates.AdmissionConfigDataName: string(config),
+		}
+		return controllerutil.SetControllerReference(kcp, cm, r.Scheme)
+	}); err != nil {
+		return errors.Wrap(err, "failed to write the admission configuration")
+	}
+
+	return nil
+}
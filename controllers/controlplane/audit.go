@@ -0,0 +1,126 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// lookupOrSetupAuditPolicy writes the audit policy of KinkControlPlane into a ConfigMap, which is
+// mounted into the API Server pods; the ConfigMap is removed when the audit policy is referenced
+// from a ConfigMap of the users or the requests are not audited. The hash of the referenced audit
+// policy is recorded in the status, so the API Servers are rolled when it is changed.
+func (r *KinkControlPlaneReconciler) lookupOrSetupAuditPolicy(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	if audit := kcp.Spec.Audit; audit != nil && audit.Policy != nil && audit.PolicyRef != nil {
+		return fmt.Errorf("only one of policy and policyRef can be set in the audit of KinkControlPlane")
+	}
+
+	kcp.Status.AuditPolicyHash = ""
+	if audit := kcp.Spec.Audit; audit != nil && audit.PolicyRef != nil {
+		ref := &v1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: audit.PolicyRef.Name}, ref); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return errors.Wrap(err, "failed to get the referenced audit policy")
+			}
+		} else if data, found := ref.Data[audit.PolicyRef.Key]; found {
+			kcp.Status.AuditPolicyHash = templates.AuditPolicyHash([]byte(data))
+		}
+	}
+
+	policy, err := templates.BuildAuditPolicy(kcp)
+	if err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      templates.AuditPolicyConfigMapName(cluster),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	if policy == nil {
+		if err := r.Get(ctx, types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		if err := r.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to remove the audit policy")
+		}
+		return nil
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = map[string]string{
+			clusterv1.ClusterLabelName: cluster.Name,
+		}
+		cm.Data = map[string]string{
+			templates.AuditPolicyDataName: string(policy),
+		}
+		return controllerutil.SetControllerReference(kcp, cm, r.Scheme)
+	}); err != nil {
+		return errors.Wrap(err, "failed to write the audit policy")
+	}
+
+	return nil
+}
+
+// ConfigMapToKinkCtrlPlane maps the ConfigMap referenced as the audit policy to the
+// KinkControlPlanes referencing it.
+func (r *KinkControlPlaneReconciler) ConfigMapToKinkCtrlPlane(o client.Object) []reconcile.Request {
+	cm, ok := o.(*v1.ConfigMap)
+	if !ok {
+		panic(fmt.Sprintf("Expected a ConfigMap but got a %T", o))
+	}
+
+	kcps := &ctrlv1beta1.KinkControlPlaneList{}
+	if err := r.List(context.Background(), kcps, client.InNamespace(cm.Namespace)); err != nil {
+		return nil
+	}
+
+	var res []reconcile.Request
+	for i := range kcps.Items {
+		kcp := &kcps.Items[i]
+		if audit := kcp.Spec.Audit; audit == nil || audit.PolicyRef == nil || audit.PolicyRef.Name != cm.Name {
+			continue
+		}
+		res = append(res, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(kcp),
+		})
+	}
+
+	return res
+}
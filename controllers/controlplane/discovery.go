@@ -0,0 +1,308 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/controllers/remote"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
+)
+
+const (
+	componentLabelName = "kink.openbce.io/component"
+	discoveryComponent = "oidc-discovery"
+
+	defaultDiscoveryImage = "nginx:1.23-alpine"
+
+	oidcConfigDataName = "openid-configuration"
+	jwksDataName       = "jwks"
+
+	discoveryRoleBindingName = "kink:service-account-issuer-discovery"
+)
+
+// lookupOrSetupDiscovery exposes the discovery documents of the service account issuer according
+// to the ServiceAccount of KinkControlPlane.
+func (r *KinkControlPlaneReconciler) lookupOrSetupDiscovery(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, certs *secrets.CertificatesManager) error {
+	var mode ctrlv1beta1.ServiceAccountDiscoveryMode
+	if sa := kcp.Spec.ServiceAccount; sa != nil && sa.Discovery != nil {
+		mode = sa.Discovery.Mode
+	}
+
+	if mode != ctrlv1beta1.ConfigMapDiscovery {
+		if err := r.cleanupDiscoveryServer(ctx, cluster); err != nil {
+			return err
+		}
+	}
+
+	switch mode {
+	case ctrlv1beta1.APIServerDiscovery:
+		return r.setupAPIServerDiscovery(ctx, cluster, kcp)
+	case ctrlv1beta1.ConfigMapDiscovery:
+		return r.setupDiscoveryServer(ctx, cluster, kcp, certs)
+	}
+
+	return nil
+}
+
+// setupAPIServerDiscovery allows the anonymous users to read the discovery documents from the
+// API Server of the tenant cluster.
+func (r *KinkControlPlaneReconciler) setupAPIServerDiscovery(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	// Wait for the API Server of the tenant cluster.
+	if !kcp.Status.Initialized {
+		return nil
+	}
+
+	c, err := remote.NewClusterClient(ctx, "kink", r.Client, util.ObjectKey(cluster))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the tenant cluster")
+	}
+
+	crb := &rbacv1.ClusterRoleBinding{}
+	if err := c.Get(ctx, types.NamespacedName{Name: discoveryRoleBindingName}, crb); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		crb = &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: discoveryRoleBindingName,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     "system:service-account-issuer-discovery",
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					APIGroup: rbacv1.GroupName,
+					Kind:     rbacv1.GroupKind,
+					Name:     "system:unauthenticated",
+				},
+			},
+		}
+		if err := c.Create(ctx, crb); err != nil {
+			return errors.Wrap(err, "failed to allow anonymous access to the discovery documents")
+		}
+	}
+
+	return nil
+}
+
+// setupDiscoveryServer writes the discovery documents into a ConfigMap, which is served by a
+// static web server.
+func (r *KinkControlPlaneReconciler) setupDiscoveryServer(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, certs *secrets.CertificatesManager) error {
+	config, jwks, err := certs.BuildOIDCDiscovery(
+		secrets.GetServiceAccountIssuer(cluster, kcp),
+		secrets.GetServiceAccountJWKSURI(cluster, kcp))
+	if err != nil {
+		return errors.Wrap(err, "failed to build the discovery documents")
+	}
+
+	name := cluster.Name + "-" + discoveryComponent
+	labels := map[string]string{
+		clusterv1.ClusterLabelName: cluster.Name,
+		componentLabelName:         discoveryComponent,
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+		},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = labels
+		cm.Data = map[string]string{
+			oidcConfigDataName: string(config),
+			jwksDataName:       string(jwks),
+		}
+		return controllerutil.SetControllerReference(kcp, cm, r.Scheme)
+	}); err != nil {
+		return errors.Wrap(err, "failed to write the discovery documents")
+	}
+
+	image := defaultDiscoveryImage
+	if len(kcp.Spec.ServiceAccount.Discovery.Image) > 0 {
+		image = kcp.Spec.ServiceAccount.Discovery.Image
+	}
+
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, deploy); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		deploy = buildDiscoveryDeployment(kcp, cluster, name, image, labels)
+		if err := r.Create(ctx, deploy); err != nil {
+			return errors.Wrap(err, "failed to create the discovery server")
+		}
+	}
+
+	svc := &v1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, svc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		svc = buildDiscoveryService(kcp, cluster, name, labels)
+		if err := r.Create(ctx, svc); err != nil {
+			return errors.Wrap(err, "failed to create the service of discovery server")
+		}
+	}
+
+	return nil
+}
+
+// cleanupDiscoveryServer removes the static web server when ConfigMap mode is not used.
+func (r *KinkControlPlaneReconciler) cleanupDiscoveryServer(ctx context.Context, cluster *clusterv1.Cluster) error {
+	name := cluster.Name + "-" + discoveryComponent
+	objs := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: cluster.Namespace, Name: name}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: cluster.Namespace, Name: name}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: cluster.Namespace, Name: name}},
+	}
+
+	for _, obj := range objs {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func buildDiscoveryDeployment(kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster,
+	name, image string, labels map[string]string) *appsv1.Deployment {
+	owner := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       cluster.Namespace,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32(1),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Name:  "server",
+							Image: image,
+							Ports: []v1.ContainerPort{
+								{
+									Name:          "http",
+									ContainerPort: 80,
+								},
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "documents",
+									MountPath: "/usr/share/nginx/html",
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "documents",
+							VolumeSource: v1.VolumeSource{
+								ConfigMap: &v1.ConfigMapVolumeSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: name,
+									},
+									Items: []v1.KeyToPath{
+										{
+											Key:  oidcConfigDataName,
+											Path: ".well-known/openid-configuration",
+										},
+										{
+											Key:  jwksDataName,
+											Path: "openid/v1/jwks",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func buildDiscoveryService(kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster,
+	name string, labels map[string]string) *v1.Service {
+	owner := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
+
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       cluster.Namespace,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Name:       "http",
+					Port:       80,
+					TargetPort: intstr.FromString("http"),
+				},
+			},
+			Selector: labels,
+			Type:     v1.ServiceTypeClusterIP,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// lookupOrSetupPodDisruptionBudgets protects the control plane pods of the cluster from the
+// voluntary disruptions, e.g. draining the nodes of the host cluster, by the PodDisruptionBudgets
+// of each role; they are removed when KinkControlPlane has no more than one replica.
+func (r *KinkControlPlaneReconciler) lookupOrSetupPodDisruptionBudgets(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	pdbs := templates.PodDisruptionBudgetTemplates(cluster, kcp)
+
+	for _, role := range infrav1beta1.ControlPlaneRoles {
+		pdb := &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      templates.PodDisruptionBudgetName(cluster, role),
+				Namespace: cluster.Namespace,
+			},
+		}
+
+		desired, found := pdbs[role]
+		if !found {
+			if err := r.Delete(ctx, pdb); err != nil && !apierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "failed to remove the PodDisruptionBudget of %s", role)
+			}
+			continue
+		}
+
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, pdb, func() error {
+			pdb.Labels = desired.Labels
+			pdb.Spec.MinAvailable = desired.Spec.MinAvailable
+			pdb.Spec.MaxUnavailable = desired.Spec.MaxUnavailable
+			pdb.Spec.Selector = desired.Spec.Selector
+			return controllerutil.SetControllerReference(kcp, pdb, r.Scheme)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to write the PodDisruptionBudget of %s", role)
+		}
+	}
+
+	return nil
+}
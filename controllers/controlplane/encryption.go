@@ -0,0 +1,134 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/controllers/remote"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// reencryptPageSize is how many objects are listed at a time when re-encrypting a resource.
+const reencryptPageSize = 500
+
+// lookupOrRotateEncryptionKeys rotates the keys of the encryption at rest; the API Server pods
+// are checked for the configuration they have loaded, and the resources are re-encrypted through
+// the API Server of the tenant cluster.
+func (r *KinkControlPlaneReconciler) lookupOrRotateEncryptionKeys(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, certs *secrets.CertificatesManager) (*ctrlv1beta1.EncryptionStatus, time.Duration, error) {
+	observed := func(status *ctrlv1beta1.EncryptionStatus) (bool, error) {
+		return r.isEncryptionConfigObserved(ctx, cluster, status)
+	}
+	reencrypt := func(resources []string) error {
+		return r.reencryptResources(ctx, cluster, resources)
+	}
+
+	return certs.LookupOrRotateEncryptionKeys(observed, reencrypt)
+}
+
+// isEncryptionConfigObserved checks whether all the API Server pods of the cluster are running
+// with the encryption configuration of the given state.
+func (r *KinkControlPlaneReconciler) isEncryptionConfigObserved(ctx context.Context, cluster *clusterv1.Cluster,
+	status *ctrlv1beta1.EncryptionStatus) (bool, error) {
+	pods := &v1.PodList{}
+	if err := r.List(ctx, pods,
+		client.InNamespace(cluster.Namespace),
+		client.MatchingLabels{
+			clusterv1.ClusterLabelName:             cluster.Name,
+			infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+		},
+	); err != nil {
+		return false, errors.Wrap(err, "failed to list API Server pods")
+	}
+
+	hash := templates.EncryptionConfigHash(status)
+
+	running := 0
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Annotations[templates.EncryptionConfigAnnotationName] != hash || pod.Status.Phase != v1.PodRunning {
+			return false, nil
+		}
+		running++
+	}
+
+	return running > 0, nil
+}
+
+// reencryptResources rewrites all the objects of the resources without changes, so they are
+// stored encrypted by the active key.
+func (r *KinkControlPlaneReconciler) reencryptResources(ctx context.Context, cluster *clusterv1.Cluster, resources []string) error {
+	logger := log.FromContext(ctx)
+
+	c, err := remote.NewClusterClient(ctx, "kink", r.Client, util.ObjectKey(cluster))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the tenant cluster")
+	}
+
+	for _, res := range resources {
+		gvr := schema.ParseGroupResource(res).WithVersion("")
+		gvk, err := c.RESTMapper().KindFor(gvr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to find the kind of %s", res)
+		}
+
+		count := 0
+		continueToken := ""
+		for {
+			list := &unstructured.UnstructuredList{}
+			list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+			if err := c.List(ctx, list, client.Limit(reencryptPageSize), client.Continue(continueToken)); err != nil {
+				return errors.Wrapf(err, "failed to list %s", res)
+			}
+
+			for i := range list.Items {
+				// The objects updated or removed meanwhile are already re-encrypted or gone.
+				if err := c.Update(ctx, &list.Items[i]); err != nil && !apierrors.IsConflict(err) && !apierrors.IsNotFound(err) {
+					return errors.Wrapf(err, "failed to re-encrypt %s %s", res, client.ObjectKeyFromObject(&list.Items[i]))
+				}
+			}
+			count += len(list.Items)
+
+			if continueToken = list.GetContinue(); len(continueToken) == 0 {
+				break
+			}
+		}
+
+		logger.Info("Re-encrypted resources", "resource", res, "count", count)
+	}
+
+	return nil
+}
@@ -19,14 +19,25 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
+
 	"github.com/pkg/errors"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/apiserver/pkg/storage/names"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -37,13 +48,40 @@ import (
 	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
 	"openbce.io/kink/controllers/controlplane/secrets"
+	"openbce.io/kink/controllers/infrastructure"
+	"openbce.io/kink/controllers/infrastructure/templates"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+// invalidConfigurationReason is the FailureReason of KinkControlPlane when its spec is rejected.
+const invalidConfigurationReason = "InvalidConfiguration"
+
+// kcpConditions are the conditions of KinkControlPlane summarized into its Ready condition, in
+// the order of the reconciling.
+var kcpConditions = []clusterv1.ConditionType{
+	ctrlv1beta1.CertificatesAvailableCondition,
+	ctrlv1beta1.KubeconfigAvailableCondition,
+	ctrlv1beta1.ResourcesAvailableCondition,
+	ctrlv1beta1.MachinesOwnedCondition,
+	ctrlv1beta1.MachinesCreatedCondition,
+	ctrlv1beta1.MachinesReadyCondition,
+	ctrlv1beta1.MachinesUpToDateCondition,
+	ctrlv1beta1.MachinesHealthyCondition,
+	ctrlv1beta1.EtcdClusterHealthyCondition,
+	ctrlv1beta1.APIServerAvailableCondition,
+}
+
+// markFailed records the error of a step by its condition, and returns the error.
+func markFailed(kcp *ctrlv1beta1.KinkControlPlane, t clusterv1.ConditionType, reason string, err error) error {
+	conditions.MarkFalse(kcp, t, reason, clusterv1.ConditionSeverityWarning, "%s", err.Error())
+	return err
+}
+
 // KinkControlPlaneReconciler reconciles a KinkControlPlane object
 type KinkControlPlaneReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=kinkcontrolplanes,verbs=get;list;watch;create;update;patch;delete
@@ -53,10 +91,16 @@ type KinkControlPlaneReconciler struct {
 //+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters/status,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-func (r *KinkControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *KinkControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reterr error) {
 	logger := log.FromContext(ctx)
 
 	// Step 1: get KinkControlPlane instance
@@ -65,21 +109,42 @@ func (r *KinkControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		if apierrors.IsNotFound(err) {
 			return ctrl.Result{}, nil
 		}
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, err
 	}
 
+	patchHelper, err := patch.NewHelper(kcp, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Always write the status and the conditions back, whatever the result of the reconciling.
+	defer func() {
+		conditions.SetSummary(kcp, conditions.WithConditions(kcpConditions...))
+
+		if err := patchHelper.Patch(ctx, kcp,
+			patch.WithOwnedConditions{Conditions: append(kcpConditions, clusterv1.ReadyCondition)},
+		); err != nil {
+			logger.Error(err, "Failed to patch KinkControlPlane", "KinkControlPlane", kcp)
+			reterr = kerrors.NewAggregate([]error{reterr, err})
+		}
+	}()
+
+	// Declare that Node objects do not exist in the cluster
+	kcp.Status.ExternalManagedControlPlane = true
+
 	cluster := &clusterv1.Cluster{}
 	clusterName := types.NamespacedName{
 		Namespace: kcp.Namespace,
 		Name:      kcp.Spec.ClusterName,
 	}
 	if err := r.Get(ctx, clusterName, cluster); err != nil {
-		logger.Error(err, "Failed to get cluster for KinkControlPlane", "KinkControlPlane", kcp)
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, errors.Wrap(err, "failed to get cluster for KinkControlPlane")
 	}
 
 	if !cluster.Status.InfrastructureReady {
 		logger.Info("Waiting for cluster infrastructure ready.", "cluster", cluster)
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesCreatedCondition, ctrlv1beta1.WaitingForClusterInfrastructureReason,
+			clusterv1.ConditionSeverityInfo, "Waiting for the infrastructure of cluster %s", cluster.Name)
 		return ctrl.Result{}, nil
 	}
 
@@ -87,35 +152,127 @@ func (r *KinkControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	endpoint := cluster.Spec.ControlPlaneEndpoint
 	if !cluster.Spec.ControlPlaneEndpoint.IsValid() || endpoint.IsZero() {
 		logger.Info("Cluster does not yet have a ControlPlaneEndpoint defined")
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesCreatedCondition, ctrlv1beta1.WaitingForClusterInfrastructureReason,
+			clusterv1.ConditionSeverityInfo, "Waiting for the control plane endpoint of cluster %s", cluster.Name)
+		return ctrl.Result{}, nil
+	}
+
+	// Reject the invalid customization of the components until it is fixed.
+	if err := templates.ValidateComponents(kcp); err != nil {
+		reason, message := invalidConfigurationReason, err.Error()
+		kcp.Status.FailureReason = &reason
+		kcp.Status.FailureMessage = &message
 		return ctrl.Result{}, nil
 	}
+	kcp.Status.FailureReason = nil
+	kcp.Status.FailureMessage = nil
 
 	// Step 2: generate CA & kubeconf for control plane & data plane
 	certs := secrets.NewCertificatesManager(ctx, r.Client, cluster, kcp)
 	if err := certs.LookupOrGenerateCAs(); err != nil {
-		logger.Error(err, "Failed to find certifications for KinkControlPlane", "KinkControlPlane", kcp)
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.CertificatesAvailableCondition, ctrlv1beta1.CertificatesGenerationFailedReason,
+			errors.Wrap(err, "failed to generate CAs"))
+	}
+
+	// Step 3: generate or rotate the signing keys of service account
+	saKeys, saRequeueAfter, err := certs.LookupOrRotateSAKeys()
+	if err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.CertificatesAvailableCondition, ctrlv1beta1.CertificatesGenerationFailedReason,
+			errors.Wrap(err, "failed to rotate service account keys"))
+	}
+	kcp.Status.ServiceAccountKeys = saKeys
+
+	saIssuers, issuerRequeueAfter := secrets.UpdateServiceAccountIssuers(cluster, kcp)
+	kcp.Status.ServiceAccountIssuers = saIssuers
+	if issuerRequeueAfter > 0 && (saRequeueAfter == 0 || issuerRequeueAfter < saRequeueAfter) {
+		saRequeueAfter = issuerRequeueAfter
+	}
+
+	// Step 4: generate or rotate the keys of encryption at rest
+	encryption, encryptionRequeueAfter, err := r.lookupOrRotateEncryptionKeys(ctx, cluster, kcp, certs)
+	if err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.CertificatesAvailableCondition, ctrlv1beta1.CertificatesGenerationFailedReason,
+			errors.Wrap(err, "failed to rotate encryption keys"))
 	}
+	kcp.Status.Encryption = encryption
+	conditions.MarkTrue(kcp, ctrlv1beta1.CertificatesAvailableCondition)
 
-	// Step 3: generate kubeconfig for bootstraps
+	// Step 5: generate kubeconfig for bootstraps and components
 	if err := certs.LookupOrGenerateKubeconfig(); err != nil {
-		return ctrl.Result{Requeue: true}, errors.Wrap(err, "failed to retrieve kubeconfig Secret")
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.KubeconfigAvailableCondition, ctrlv1beta1.KubeconfigGenerationFailedReason,
+			errors.Wrap(err, "failed to retrieve kubeconfig Secret"))
+	}
+	if err := certs.LookupOrGenerateComponentKubeconfigs(); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.KubeconfigAvailableCondition, ctrlv1beta1.KubeconfigGenerationFailedReason,
+			errors.Wrap(err, "failed to generate kubeconfig of components"))
+	}
+	conditions.MarkTrue(kcp, ctrlv1beta1.KubeconfigAvailableCondition)
+
+	// Step 6: write the audit policy for the API Server
+	if err := r.lookupOrSetupAuditPolicy(ctx, cluster, kcp); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason,
+			errors.Wrap(err, "failed to setup audit policy"))
+	}
+
+	// Step 7: write the admission configuration of the security profile for the API Server
+	if err := r.lookupOrSetupAdmissionConfig(ctx, cluster, kcp); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason,
+			errors.Wrap(err, "failed to setup admission configuration"))
 	}
 
-	// Step 4: lookup or create KinkMachine of this KinkControlPlane
-	if err := r.lookupOrCreateMachines(ctx, cluster, kcp); err != nil {
-		return ctrl.Result{Requeue: true}, err
+	// Step 8: isolate the control plane pods by NetworkPolicies
+	if err := r.lookupOrSetupNetworkPolicies(ctx, cluster, kcp); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason,
+			errors.Wrap(err, "failed to setup network policies"))
+	}
+
+	// Step 9: protect the control plane pods by PodDisruptionBudgets
+	if err := r.lookupOrSetupPodDisruptionBudgets(ctx, cluster, kcp); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason,
+			errors.Wrap(err, "failed to setup pod disruption budgets"))
+	}
+
+	// Step 10: expose the etcd and API Server pods by the Services, on the network they run on
+	if err := r.keepHostNetwork(ctx, cluster, kcp); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason, err)
+	}
+	if err := r.lookupOrSetupServices(ctx, cluster, kcp); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason,
+			errors.Wrap(err, "failed to setup services"))
 	}
 
-	// Step 5: update KinkControlPlane's status accordingly
+	// Step 11: lookup or create KinkMachine of this KinkControlPlane
+	drainRequeueAfter, err := r.lookupOrCreateMachines(ctx, cluster, kcp)
+	if err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.MachinesCreatedCondition, ctrlv1beta1.MachinesScalingFailedReason, err)
+	}
+
+	// Step 12: update KinkControlPlane's status accordingly
 	if err := r.updateKinkCtlPlaneStatus(ctx, kcp); err != nil {
-		return ctrl.Result{Requeue: true}, err
+		return ctrl.Result{}, err
+	}
+
+	// Step 13: expose the discovery documents of service account issuer
+	if err := r.lookupOrSetupDiscovery(ctx, cluster, kcp, certs); err != nil {
+		return ctrl.Result{}, markFailed(kcp, ctrlv1beta1.ResourcesAvailableCondition, ctrlv1beta1.ResourcesSetupFailedReason,
+			errors.Wrap(err, "failed to setup discovery documents"))
+	}
+	conditions.MarkTrue(kcp, ctrlv1beta1.ResourcesAvailableCondition)
+
+	requeueAfter := saRequeueAfter
+	if encryptionRequeueAfter > 0 && (requeueAfter == 0 || encryptionRequeueAfter < requeueAfter) {
+		requeueAfter = encryptionRequeueAfter
+	}
+	if drainRequeueAfter > 0 && (requeueAfter == 0 || drainRequeueAfter < requeueAfter) {
+		requeueAfter = drainRequeueAfter
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-func (r *KinkControlPlaneReconciler) lookupOrCreateMachines(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+// lookupOrCreateMachines scales the KinkMachines to the replicas of KinkControlPlane, and returns
+// how long to wait for the API Server pods of the removed KinkMachines to be drained.
+func (r *KinkControlPlaneReconciler) lookupOrCreateMachines(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) (time.Duration, error) {
 	logger := log.FromContext(ctx)
 
 	kms := &infrav1beta1.KinkMachineList{}
@@ -125,7 +282,17 @@ func (r *KinkControlPlaneReconciler) lookupOrCreateMachines(ctx context.Context,
 			clusterv1.ClusterLabelName: cluster.Name,
 		},
 	); err != nil {
-		return errors.Wrap(err, "failed to list machines")
+		return 0, errors.Wrap(err, "failed to list machines")
+	}
+
+	machines, err := r.filterOwnedMachines(ctx, kcp, kms.Items)
+	if err != nil {
+		return 0, err
+	}
+
+	remediationRequeueAfter, remediating, err := r.remediateUnhealthyMachines(ctx, cluster, kcp, machines)
+	if err != nil || remediating {
+		return remediationRequeueAfter, err
 	}
 
 	var replicas int32
@@ -133,10 +300,21 @@ func (r *KinkControlPlaneReconciler) lookupOrCreateMachines(ctx context.Context,
 		replicas = *kcp.Spec.Replicas
 	}
 
+	switch current := int32(len(machines)); {
+	case current < replicas:
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesCreatedCondition, ctrlv1beta1.ScalingUpReason,
+			clusterv1.ConditionSeverityInfo, "Scaling up from %d to %d replicas", current, replicas)
+	case current > replicas:
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesCreatedCondition, ctrlv1beta1.ScalingDownReason,
+			clusterv1.ConditionSeverityInfo, "Scaling down from %d to %d replicas", current, replicas)
+	default:
+		conditions.MarkTrue(kcp, ctrlv1beta1.MachinesCreatedCondition)
+	}
+
 	owner := metav1.NewControllerRef(kcp,
 		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
 
-	for i := len(kms.Items); int32(i) < replicas; i++ {
+	for i := len(machines); int32(i) < replicas; i++ {
 		m := infrav1beta1.KinkMachine{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      names.SimpleNameGenerator.GenerateName(cluster.Name + "-"),
@@ -157,16 +335,78 @@ func (r *KinkControlPlaneReconciler) lookupOrCreateMachines(ctx context.Context,
 		}
 	}
 
-	for i := len(kms.Items); int32(i) > replicas; i-- {
-		if err := r.Delete(ctx, &kms.Items[i-1]); err != nil {
-			logger.Error(err, "Filed to delete KinkMachine from KinkControlPlane", "KinkControlPlane", kcp)
+	requeueAfter := remediationRequeueAfter
+	if int32(len(machines)) > replicas {
+		if km := selectMachineToRemove(kcp, machines); km != nil {
+			wait, err := r.drainMachine(ctx, cluster, km)
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to drain %s", km.Name)
+			}
+			if wait > 0 {
+				if requeueAfter == 0 || wait < requeueAfter {
+					requeueAfter = wait
+				}
+			} else if err := r.Delete(ctx, km); err != nil && !apierrors.IsNotFound(err) {
+				return 0, errors.Wrapf(err, "failed to delete %s", km.Name)
+			}
+		}
+	}
+
+	if err := r.rolloutMachines(ctx, kcp, machines, replicas); err != nil {
+		return 0, err
+	}
+
+	return requeueAfter, nil
+}
+
+// drainMachine marks the KinkMachine as being removed, so its pods are not restored by the
+// KinkMachine controller, and takes its API Server pods out of the endpoints of the API Server
+// Service; it returns how long to wait before the KinkMachine can be deleted.
+func (r *KinkControlPlaneReconciler) drainMachine(ctx context.Context, cluster *clusterv1.Cluster, km *infrav1beta1.KinkMachine) (time.Duration, error) {
+	if _, found := km.Annotations[infrav1beta1.DrainStartedAnnotationName]; !found {
+		patch := client.MergeFrom(km.DeepCopy())
+		if km.Annotations == nil {
+			km.Annotations = map[string]string{}
+		}
+		km.Annotations[infrav1beta1.DrainStartedAnnotationName] = time.Now().UTC().Format(time.RFC3339)
+		if err := r.Patch(ctx, km, patch); err != nil {
+			return 0, errors.Wrap(err, "failed to mark machine as draining")
+		}
+	}
+
+	pods := &v1.PodList{}
+	if err := r.List(ctx, pods,
+		client.InNamespace(cluster.Namespace),
+		client.MatchingLabels{
+			clusterv1.ClusterLabelName:             cluster.Name,
+			infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+		},
+	); err != nil {
+		return 0, errors.Wrap(err, "failed to list api server pods")
+	}
+
+	var requeueAfter time.Duration
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !metav1.IsControlledBy(pod, km) {
 			continue
 		}
+
+		wait, err := infrastructure.DrainAPIServerPod(ctx, r.Client, pod)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to drain pod %s", pod.Name)
+		}
+		if wait > requeueAfter {
+			requeueAfter = wait
+		}
 	}
 
-	return nil
+	return requeueAfter, nil
 }
 
+// updateKinkCtlPlaneStatus counts the ready KinkMachines, records the minimum Kubernetes version
+// they run, and derives the health of the etcd cluster and of the API Servers from their
+// conditions.
 func (r *KinkControlPlaneReconciler) updateKinkCtlPlaneStatus(ctx context.Context, kcp *ctrlv1beta1.KinkControlPlane) error {
 	kms := &infrav1beta1.KinkMachineList{}
 	if err := r.Client.List(ctx, kms,
@@ -179,16 +419,41 @@ func (r *KinkControlPlaneReconciler) updateKinkCtlPlaneStatus(ctx context.Contex
 	}
 
 	var readyReplicas, unavailableReplicas int32
-	for _, m := range kms.Items {
-		if !metav1.IsControlledBy(&m, kcp) {
+	var machines []conditions.Getter
+	var etcdMembers, readyEtcdMembers, readyAPIServers int
+	var minVersion *version.Version
+	for i := range kms.Items {
+		m := &kms.Items[i]
+		if !metav1.IsControlledBy(m, kcp) {
 			continue
 		}
 
+		if v := templates.GetKubernetesVersion(m); minVersion == nil || v.LessThan(minVersion) {
+			minVersion = v
+		}
+
 		if m.Status.Ready {
 			readyReplicas++
 		} else {
 			unavailableReplicas++
 		}
+
+		machines = append(machines, m)
+
+		if m.DeletionTimestamp == nil {
+			etcdMembers++
+			if conditions.IsTrue(m, infrav1beta1.EtcdReadyCondition) {
+				readyEtcdMembers++
+			}
+		}
+		if conditions.IsTrue(m, infrav1beta1.APIServerReadyCondition) {
+			readyAPIServers++
+		}
+	}
+
+	kcp.Status.Version = nil
+	if minVersion != nil {
+		kcp.Status.Version = pointer.String("v" + minVersion.String())
 	}
 
 	kcp.Status.ReadyReplicas = readyReplicas
@@ -202,11 +467,31 @@ func (r *KinkControlPlaneReconciler) updateKinkCtlPlaneStatus(ctx context.Contex
 		kcp.Status.Ready = false
 	}
 
-	// Declare that Node objects do not exist in the cluster
-	kcp.Status.ExternalManagedControlPlane = true
+	if len(machines) > 0 {
+		conditions.SetAggregate(kcp, ctrlv1beta1.MachinesReadyCondition, machines, conditions.AddSourceRef())
+	} else {
+		conditions.Delete(kcp, ctrlv1beta1.MachinesReadyCondition)
+	}
 
-	if err := r.Status().Update(ctx, kcp); err != nil {
-		return err
+	switch {
+	case etcdMembers > 0 && readyEtcdMembers >= etcdMembers:
+		conditions.MarkTrue(kcp, ctrlv1beta1.EtcdClusterHealthyCondition)
+	case readyEtcdMembers == 0 && !kcp.Status.Initialized:
+		conditions.MarkFalse(kcp, ctrlv1beta1.EtcdClusterHealthyCondition, ctrlv1beta1.WaitingForEtcdReason,
+			clusterv1.ConditionSeverityInfo, "Waiting for the etcd members to be ready")
+	case readyEtcdMembers > 0:
+		conditions.MarkFalse(kcp, ctrlv1beta1.EtcdClusterHealthyCondition, ctrlv1beta1.EtcdMembersUnhealthyReason,
+			clusterv1.ConditionSeverityWarning, "%d of %d etcd members are ready", readyEtcdMembers, etcdMembers)
+	default:
+		conditions.MarkFalse(kcp, ctrlv1beta1.EtcdClusterHealthyCondition, ctrlv1beta1.EtcdUnavailableReason,
+			clusterv1.ConditionSeverityError, "None of %d etcd members is ready", etcdMembers)
+	}
+
+	if readyAPIServers > 0 {
+		conditions.MarkTrue(kcp, ctrlv1beta1.APIServerAvailableCondition)
+	} else {
+		conditions.MarkFalse(kcp, ctrlv1beta1.APIServerAvailableCondition, ctrlv1beta1.APIServerUnavailableReason,
+			clusterv1.ConditionSeverityWarning, "No API Server is ready")
 	}
 
 	return nil
@@ -220,9 +505,18 @@ func (r *KinkControlPlaneReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&v1.ConfigMap{}).
 		Owns(&v1.Secret{}).
 		Owns(&v1.Service{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
 		Watches(
 			&source.Kind{Type: &clusterv1.Cluster{}},
 			handler.EnqueueRequestsFromMapFunc(r.ClusterToKinkCtrlPlane)).
+		Watches(
+			&source.Kind{Type: &infrav1beta1.KinkMachine{}},
+			handler.EnqueueRequestsFromMapFunc(r.MachineToKinkCtrlPlane)).
+		Watches(
+			&source.Kind{Type: &v1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.ConfigMapToKinkCtrlPlane)).
 		Complete(r)
 }
 
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// lookupOrSetupNetworkPolicies isolates the control plane pods of the cluster by the
+// NetworkPolicies of each role; they are removed when the NetworkPolicy of KinkControlPlane is
+// disabled.
+func (r *KinkControlPlaneReconciler) lookupOrSetupNetworkPolicies(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	policies := templates.NetworkPolicyTemplates(cluster, kcp)
+
+	for _, role := range infrav1beta1.ControlPlaneRoles {
+		np := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      templates.NetworkPolicyName(cluster, role),
+				Namespace: cluster.Namespace,
+			},
+		}
+
+		desired, found := policies[role]
+		if !found {
+			if err := r.Delete(ctx, np); err != nil && !apierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "failed to remove the NetworkPolicy of %s", role)
+			}
+			continue
+		}
+
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, np, func() error {
+			np.Labels = desired.Labels
+			np.Spec = desired.Spec
+			return controllerutil.SetControllerReference(kcp, np, r.Scheme)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to write the NetworkPolicy of %s", role)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// filterOwnedMachines returns the KinkMachines of the cluster controlled by the KinkControlPlane.
+// The control plane KinkMachines without a controller are adopted; the ones controlled by another
+// object are reported by the MachinesOwned condition, and left alone. The other KinkMachines,
+// e.g. the workers, are ignored.
+func (r *KinkControlPlaneReconciler) filterOwnedMachines(ctx context.Context, kcp *ctrlv1beta1.KinkControlPlane, machines []infrav1beta1.KinkMachine) ([]infrav1beta1.KinkMachine, error) {
+	logger := log.FromContext(ctx)
+
+	var owned []infrav1beta1.KinkMachine
+	var foreign []string
+
+	for i := range machines {
+		m := &machines[i]
+
+		if metav1.IsControlledBy(m, kcp) {
+			owned = append(owned, *m)
+			continue
+		}
+
+		if _, found := m.Labels[clusterv1.MachineControlPlaneLabelName]; !found {
+			continue
+		}
+
+		if metav1.GetControllerOf(m) != nil {
+			foreign = append(foreign, m.Name)
+			continue
+		}
+
+		// Do not adopt the KinkMachines being deleted, or while the KinkControlPlane is deleted.
+		if m.DeletionTimestamp != nil || kcp.DeletionTimestamp != nil {
+			continue
+		}
+
+		patch := client.MergeFrom(m.DeepCopy())
+		if err := controllerutil.SetControllerReference(kcp, m, r.Scheme); err != nil {
+			return nil, errors.Wrapf(err, "failed to adopt machine %s", m.Name)
+		}
+		if err := r.Patch(ctx, m, patch); err != nil {
+			return nil, errors.Wrapf(err, "failed to adopt machine %s", m.Name)
+		}
+		logger.Info("Adopted KinkMachine", "KinkMachine", m.Name, "KinkControlPlane", kcp.Name)
+
+		owned = append(owned, *m)
+	}
+
+	if len(foreign) > 0 {
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesOwnedCondition, ctrlv1beta1.ForeignMachinesReason,
+			clusterv1.ConditionSeverityError, "KinkMachines controlled by another object: %s", strings.Join(foreign, ", "))
+	} else {
+		conditions.MarkTrue(kcp, ctrlv1beta1.MachinesOwnedCondition)
+	}
+
+	return owned, nil
+}
+
+// MachineToKinkCtrlPlane maps the control plane KinkMachine without a controller to the
+// KinkControlPlane of its cluster, so the KinkMachine is adopted.
+func (r *KinkControlPlaneReconciler) MachineToKinkCtrlPlane(o client.Object) []reconcile.Request {
+	m, ok := o.(*infrav1beta1.KinkMachine)
+	if !ok {
+		panic(fmt.Sprintf("Expected a KinkMachine but got a %T", o))
+	}
+
+	if _, found := m.Labels[clusterv1.MachineControlPlaneLabelName]; !found || metav1.GetControllerOf(m) != nil {
+		return nil
+	}
+
+	clusterName, found := m.Labels[clusterv1.ClusterLabelName]
+	if !found {
+		return nil
+	}
+
+	cluster := &clusterv1.Cluster{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: m.Namespace, Name: clusterName}, cluster); err != nil {
+		return nil
+	}
+
+	return r.ClusterToKinkCtrlPlane(cluster)
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+const (
+	defaultUnhealthyTimeout = 5 * time.Minute
+	defaultMaxRetries       = 3
+	defaultRetryPeriod      = time.Minute
+	defaultMinHealthyPeriod = time.Hour
+
+	// maxRetryBackoff caps the doubled backoff between the retries.
+	maxRetryBackoff = time.Hour
+)
+
+// machineConditions are the conditions of KinkMachine reporting its control plane pods.
+var machineConditions = []clusterv1.ConditionType{
+	infrav1beta1.EtcdReadyCondition,
+	infrav1beta1.APIServerReadyCondition,
+	infrav1beta1.ControllerManagerReadyCondition,
+	infrav1beta1.SchedulerReadyCondition,
+}
+
+// remediationPolicy is the RemediationSpec of KinkControlPlane with the defaults.
+type remediationPolicy struct {
+	unhealthyTimeout time.Duration
+	maxRetries       int32
+	retryPeriod      time.Duration
+	minHealthyPeriod time.Duration
+}
+
+func getRemediationPolicy(kcp *ctrlv1beta1.KinkControlPlane) (*remediationPolicy, bool) {
+	policy := &remediationPolicy{
+		unhealthyTimeout: defaultUnhealthyTimeout,
+		maxRetries:       defaultMaxRetries,
+		retryPeriod:      defaultRetryPeriod,
+		minHealthyPeriod: defaultMinHealthyPeriod,
+	}
+
+	spec := kcp.Spec.Remediation
+	if spec == nil {
+		return policy, true
+	}
+	if spec.Disabled {
+		return nil, false
+	}
+
+	if spec.UnhealthyTimeout != nil {
+		policy.unhealthyTimeout = spec.UnhealthyTimeout.Duration
+	}
+	if spec.MaxRetries != nil {
+		policy.maxRetries = *spec.MaxRetries
+	}
+	if spec.RetryPeriod != nil {
+		policy.retryPeriod = spec.RetryPeriod.Duration
+	}
+	if spec.MinHealthyPeriod != nil {
+		policy.minHealthyPeriod = spec.MinHealthyPeriod.Duration
+	}
+
+	return policy, true
+}
+
+// getUnhealthySince returns when the KinkMachine became unhealthy and why, by the conditions of
+// its control plane pods.
+func getUnhealthySince(m *infrav1beta1.KinkMachine) (time.Time, string, bool) {
+	if _, found := m.Annotations[clusterv1.MachineSkipRemediationAnnotation]; found {
+		return time.Time{}, "", false
+	}
+
+	var since time.Time
+	var message string
+	for _, t := range machineConditions {
+		cond := conditions.Get(m, t)
+		if cond == nil || cond.Status != v1.ConditionFalse || cond.Reason != infrav1beta1.PodNotReadyReason {
+			continue
+		}
+
+		if len(message) == 0 || cond.LastTransitionTime.Time.Before(since) {
+			since, message = cond.LastTransitionTime.Time, cond.Message
+		}
+	}
+
+	return since, message, len(message) > 0
+}
+
+// getRetryBackoff returns the backoff before the retry, which is the RetryPeriod doubled for each
+// consecutive remediation up to maxRetryBackoff; a RetryPeriod above the cap is kept as is.
+func getRetryBackoff(retryPeriod time.Duration, retryCount int32) time.Duration {
+	backoff := retryPeriod
+	for i := int32(1); i < retryCount && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff && retryPeriod < maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+
+	return backoff
+}
+
+// remediateUnhealthyMachines replaces the KinkMachine that stays unhealthy longer than the
+// UnhealthyTimeout: it is drained and deleted like on scale-down, and a new one is created in its
+// place. Only one KinkMachine is remediated at a time, when another one is healthy.
+// It returns when to check the KinkMachines again, and whether a remediation is in progress, so
+// the KinkMachines are not scaled meanwhile.
+func (r *KinkControlPlaneReconciler) remediateUnhealthyMachines(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, machines []infrav1beta1.KinkMachine) (time.Duration, bool, error) {
+	policy, enabled := getRemediationPolicy(kcp)
+	if !enabled {
+		conditions.Delete(kcp, ctrlv1beta1.MachinesHealthyCondition)
+		return 0, false, nil
+	}
+
+	// Go on with the remediation in progress.
+	if last := kcp.Status.LastRemediation; last != nil {
+		for i := range machines {
+			if machines[i].Name == last.Machine {
+				requeueAfter, err := r.remediateMachine(ctx, cluster, kcp, &machines[i])
+				return requeueAfter, true, err
+			}
+		}
+	}
+
+	now := time.Now()
+
+	var target *infrav1beta1.KinkMachine
+	var since time.Time
+	var reason string
+	var busy bool
+	var rollingOut string
+	healthy := 0
+
+	for i := range machines {
+		m := &machines[i]
+		if m.DeletionTimestamp != nil {
+			busy = true
+			continue
+		}
+		if _, found := m.Annotations[infrav1beta1.DrainStartedAnnotationName]; found {
+			busy = true
+			continue
+		}
+		if _, found := m.Annotations[infrav1beta1.RolloutAllowedAnnotationName]; found {
+			rollingOut = m.Name
+		}
+
+		s, msg, unhealthy := getUnhealthySince(m)
+		if !unhealthy {
+			if m.Status.Ready {
+				healthy++
+			}
+			continue
+		}
+
+		if target == nil || s.Before(since) {
+			target, since, reason = m, s, msg
+		}
+	}
+
+	if target == nil {
+		conditions.MarkTrue(kcp, ctrlv1beta1.MachinesHealthyCondition)
+		return 0, false, nil
+	}
+
+	if wait := since.Add(policy.unhealthyTimeout).Sub(now); wait > 0 {
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.MachineUnhealthyReason,
+			clusterv1.ConditionSeverityWarning, "KinkMachine %s is unhealthy: %s", target.Name, reason)
+		return wait, false, nil
+	}
+
+	// The retries are reset once the KinkMachines stay healthy for a while after a remediation.
+	var retryCount int32
+	if last := kcp.Status.LastRemediation; last != nil && now.Before(last.Timestamp.Add(policy.minHealthyPeriod)) {
+		retryCount = last.RetryCount + 1
+
+		if retryCount > policy.maxRetries {
+			if !conditions.IsFalse(kcp, ctrlv1beta1.MachinesHealthyCondition) ||
+				conditions.GetReason(kcp, ctrlv1beta1.MachinesHealthyCondition) != ctrlv1beta1.RemediationFailedReason {
+				r.Recorder.Eventf(kcp, v1.EventTypeWarning, ctrlv1beta1.RemediationFailedReason,
+					"Not remediating KinkMachine %s, the %d retries are exhausted", target.Name, policy.maxRetries)
+			}
+			conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.RemediationFailedReason,
+				clusterv1.ConditionSeverityError, "KinkMachine %s is unhealthy, and the %d retries are exhausted: %s",
+				target.Name, policy.maxRetries, reason)
+			return 0, false, nil
+		}
+
+		backoff := getRetryBackoff(policy.retryPeriod, retryCount)
+		if wait := last.Timestamp.Add(backoff).Sub(now); wait > 0 {
+			conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.RemediationDeferredReason,
+				clusterv1.ConditionSeverityWarning, "KinkMachine %s is unhealthy, retrying the remediation in %s: %s",
+				target.Name, wait.Round(time.Second), reason)
+			return wait, false, nil
+		}
+	}
+
+	var replicas int
+	if kcp.Spec.Replicas != nil {
+		replicas = int(*kcp.Spec.Replicas)
+	}
+	if busy || len(machines) != replicas {
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.RemediationDeferredReason,
+			clusterv1.ConditionSeverityWarning, "KinkMachine %s is unhealthy, waiting for the scaling to complete: %s",
+			target.Name, reason)
+		return 0, false, nil
+	}
+
+	// The KinkMachine being rolled out may be out of the endpoints, unless it is the unhealthy one.
+	if len(rollingOut) > 0 && rollingOut != target.Name {
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.RemediationDeferredReason,
+			clusterv1.ConditionSeverityWarning, "KinkMachine %s is unhealthy, waiting for the rollout of %s to complete: %s",
+			target.Name, rollingOut, reason)
+		return 0, false, nil
+	}
+
+	// Another KinkMachine must keep serving the cluster while the unhealthy one is replaced.
+	if healthy == 0 {
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.RemediationDeferredReason,
+			clusterv1.ConditionSeverityWarning, "KinkMachine %s is unhealthy, but no other KinkMachine is healthy: %s",
+			target.Name, reason)
+		return 0, false, nil
+	}
+
+	kcp.Status.LastRemediation = &ctrlv1beta1.RemediationStatus{
+		Machine:    target.Name,
+		Timestamp:  metav1.NewTime(now),
+		RetryCount: retryCount,
+	}
+	conditions.MarkFalse(kcp, ctrlv1beta1.MachinesHealthyCondition, ctrlv1beta1.RemediatingReason,
+		clusterv1.ConditionSeverityWarning, "Remediating KinkMachine %s: %s", target.Name, reason)
+
+	r.Recorder.Eventf(kcp, v1.EventTypeWarning, ctrlv1beta1.RemediatingReason,
+		"Remediating unhealthy KinkMachine %s (retry %d): %s", target.Name, retryCount, reason)
+
+	// The remediation is carried out once it is recorded in the status, so it goes on across the
+	// reconciling; the status change triggers the next one.
+	return 0, true, nil
+}
+
+// remediateMachine drains and deletes the KinkMachine being remediated. The health of the
+// KinkMachine is checked again right before it is deleted: if it recovered meanwhile, the
+// remediation is aborted and the drained API Server is restored, while the retries are kept.
+func (r *KinkControlPlaneReconciler) remediateMachine(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, km *infrav1beta1.KinkMachine) (time.Duration, error) {
+	logger := log.FromContext(ctx)
+
+	if km.DeletionTimestamp != nil {
+		return 0, nil
+	}
+
+	wait, err := r.drainMachine(ctx, cluster, km)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to drain %s", km.Name)
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	latest := &infrav1beta1.KinkMachine{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(km), latest); err != nil {
+		if apierrors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrapf(err, "failed to get %s", km.Name)
+	}
+	if _, _, unhealthy := getUnhealthySince(latest); !unhealthy {
+		patch := client.MergeFrom(latest.DeepCopy())
+		delete(latest.Annotations, infrav1beta1.DrainStartedAnnotationName)
+		if err := r.Patch(ctx, latest, patch); err != nil {
+			return 0, errors.Wrapf(err, "failed to restore %s", km.Name)
+		}
+
+		// Keep the timestamp and the retries, so a flapping KinkMachine still backs off.
+		kcp.Status.LastRemediation.Machine = ""
+		logger.Info("Aborted the remediation of the recovered KinkMachine", "KinkMachine", km.Name)
+		r.Recorder.Event(kcp, v1.EventTypeNormal, "RemediationAborted",
+			fmt.Sprintf("KinkMachine %s recovered, not deleting it", km.Name))
+		return 0, nil
+	}
+
+	if err := r.Delete(ctx, km); err != nil && !apierrors.IsNotFound(err) {
+		return 0, errors.Wrapf(err, "failed to delete %s", km.Name)
+	}
+	logger.Info("Deleted unhealthy KinkMachine", "KinkMachine", km.Name)
+	r.Recorder.Event(kcp, v1.EventTypeNormal, "MachineRemediated",
+		fmt.Sprintf("Deleted unhealthy KinkMachine %s, it will be replaced", km.Name))
+
+	return 0, nil
+}
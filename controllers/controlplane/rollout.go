@@ -0,0 +1,131 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// rolloutMachines rolls the outdated KinkMachines one at a time: the KinkMachine allowed to roll
+// its pods keeps the permission until its pods are up to date and it is ready again, and the next
+// one is allowed only when all the KinkMachines are ready, so at most one API Server is out of
+// the endpoints at a time. The KinkMachines with an outdated etcd pod are only reported, as etcd
+// is never rolled.
+func (r *KinkControlPlaneReconciler) rolloutMachines(ctx context.Context, kcp *ctrlv1beta1.KinkControlPlane,
+	machines []infrav1beta1.KinkMachine, replicas int32) error {
+	logger := log.FromContext(ctx)
+
+	var current *infrav1beta1.KinkMachine
+	var outdated []*infrav1beta1.KinkMachine
+	var etcdOutdated []string
+	allReady := int32(len(machines)) == replicas
+
+	for i := range machines {
+		m := &machines[i]
+		if m.DeletionTimestamp != nil {
+			allReady = false
+			continue
+		}
+		if _, found := m.Annotations[infrav1beta1.DrainStartedAnnotationName]; found {
+			allReady = false
+			continue
+		}
+
+		if _, found := m.Annotations[infrav1beta1.RolloutAllowedAnnotationName]; found && current == nil {
+			current = m
+		}
+		if !m.Status.Ready {
+			allReady = false
+		}
+		if conditions.IsFalse(m, infrav1beta1.PodsUpToDateCondition) {
+			outdated = append(outdated, m)
+		}
+		if conditions.IsFalse(m, infrav1beta1.EtcdUpToDateCondition) {
+			etcdOutdated = append(etcdOutdated, m.Name)
+		}
+	}
+
+	if current != nil {
+		if !conditions.IsTrue(current, infrav1beta1.PodsUpToDateCondition) || !current.Status.Ready {
+			conditions.MarkFalse(kcp, ctrlv1beta1.MachinesUpToDateCondition, ctrlv1beta1.RollingOutReason,
+				clusterv1.ConditionSeverityInfo, "Rolling out KinkMachine %s", current.Name)
+			return nil
+		}
+
+		patch := client.MergeFrom(current.DeepCopy())
+		delete(current.Annotations, infrav1beta1.RolloutAllowedAnnotationName)
+		if err := r.Patch(ctx, current, patch); err != nil {
+			return errors.Wrapf(err, "failed to complete the rollout of %s", current.Name)
+		}
+		logger.Info("Rolled out KinkMachine", "KinkMachine", current.Name, "KinkControlPlane", kcp.Name)
+	}
+
+	if len(outdated) == 0 && len(etcdOutdated) > 0 {
+		sort.Strings(etcdOutdated)
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesUpToDateCondition, ctrlv1beta1.EtcdOutdatedReason,
+			clusterv1.ConditionSeverityWarning, "The etcd pods of KinkMachines %s are outdated, replace the KinkMachines to update them",
+			strings.Join(etcdOutdated, ", "))
+		return nil
+	}
+
+	if len(outdated) == 0 {
+		conditions.MarkTrue(kcp, ctrlv1beta1.MachinesUpToDateCondition)
+		return nil
+	}
+
+	if current != nil || !allReady {
+		conditions.MarkFalse(kcp, ctrlv1beta1.MachinesUpToDateCondition, ctrlv1beta1.RollingOutReason,
+			clusterv1.ConditionSeverityInfo, "%d KinkMachines are outdated, waiting for all the KinkMachines to be ready", len(outdated))
+		return nil
+	}
+
+	// Roll the oldest KinkMachine first.
+	sort.Slice(outdated, func(i, j int) bool {
+		if !outdated[i].CreationTimestamp.Equal(&outdated[j].CreationTimestamp) {
+			return outdated[i].CreationTimestamp.Before(&outdated[j].CreationTimestamp)
+		}
+		return outdated[i].Name < outdated[j].Name
+	})
+	next := outdated[0]
+
+	patch := client.MergeFrom(next.DeepCopy())
+	if next.Annotations == nil {
+		next.Annotations = map[string]string{}
+	}
+	next.Annotations[infrav1beta1.RolloutAllowedAnnotationName] = ""
+	if err := r.Patch(ctx, next, patch); err != nil {
+		return errors.Wrapf(err, "failed to start the rollout of %s", next.Name)
+	}
+	logger.Info("Rolling out KinkMachine", "KinkMachine", next.Name, "KinkControlPlane", kcp.Name)
+
+	conditions.MarkFalse(kcp, ctrlv1beta1.MachinesUpToDateCondition, ctrlv1beta1.RollingOutReason,
+		clusterv1.ConditionSeverityInfo, "Rolling out KinkMachine %s", next.Name)
+
+	return nil
+}
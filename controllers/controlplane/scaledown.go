@@ -0,0 +1,99 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// scaleDownCandidate is a KinkMachine that may be removed on scale-down.
+type scaleDownCandidate struct {
+	machine   *infrav1beta1.KinkMachine
+	draining  bool
+	rolling   bool
+	unhealthy bool
+	outdated  bool
+}
+
+// less orders the candidates by the preference of removal: the KinkMachines being drained, so the
+// choice is stable across the reconciling; then the one being rolled out, whose API Server may be
+// out of the endpoints already; then the unhealthy ones, the ones on an outdated version or with an
+// outdated etcd pod, which is updated only by replacing the KinkMachine, and the oldest ones.
+func (c *scaleDownCandidate) less(o *scaleDownCandidate) bool {
+	if c.draining != o.draining {
+		return c.draining
+	}
+	if c.rolling != o.rolling {
+		return c.rolling
+	}
+	if c.unhealthy != o.unhealthy {
+		return c.unhealthy
+	}
+	if c.outdated != o.outdated {
+		return c.outdated
+	}
+	if !c.machine.CreationTimestamp.Equal(&o.machine.CreationTimestamp) {
+		return c.machine.CreationTimestamp.Before(&o.machine.CreationTimestamp)
+	}
+
+	return c.machine.Name < o.machine.Name
+}
+
+// selectMachineToRemove returns the KinkMachine to remove on scale-down. The KinkMachines are
+// removed one at a time, so at most one API Server is out of the endpoints; it returns nil while
+// another KinkMachine is being deleted.
+//
+// The etcd leader is not avoided, and no etcd member is removed before its KinkMachine: the etcd
+// pod of each KinkMachine is a standalone single-member cluster, without --initial-cluster and
+// with its data in an emptyDir, so there is neither a leader among the KinkMachines nor a
+// membership to update. Both need the etcd pods to form one cluster first.
+func selectMachineToRemove(kcp *ctrlv1beta1.KinkControlPlane, machines []infrav1beta1.KinkMachine) *infrav1beta1.KinkMachine {
+	var candidates []*scaleDownCandidate
+	for i := range machines {
+		m := &machines[i]
+		if m.DeletionTimestamp != nil {
+			return nil
+		}
+
+		_, draining := m.Annotations[infrav1beta1.DrainStartedAnnotationName]
+		_, rolling := m.Annotations[infrav1beta1.RolloutAllowedAnnotationName]
+		candidates = append(candidates, &scaleDownCandidate{
+			machine:   m,
+			draining:  draining,
+			rolling:   rolling,
+			unhealthy: !m.Status.Ready,
+			outdated: !pointer.StringEqual(kcp.Spec.Version, m.Spec.Version) ||
+				conditions.IsFalse(m, infrav1beta1.EtcdUpToDateCondition),
+		})
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].less(candidates[j])
+	})
+
+	return candidates[0].machine
+}
@@ -30,6 +30,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	certutil "k8s.io/client-go/util/cert"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
@@ -45,7 +46,7 @@ import (
 
 var certNameFmt = "%s-%s"
 
-type configMutatorsFunc func(cfg *pkiutil.CertConfig, cluster *clusterv1.Cluster)
+type configMutatorsFunc func(cfg *pkiutil.CertConfig, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane)
 
 type KinkCert struct {
 	root *KinkCert
@@ -59,9 +60,9 @@ type KinkCert struct {
 	configMutators []configMutatorsFunc
 }
 
-func (c *KinkCert) GetConfig(cluster *clusterv1.Cluster) (*pkiutil.CertConfig, error) {
+func (c *KinkCert) GetConfig(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) (*pkiutil.CertConfig, error) {
 	for _, f := range c.configMutators {
-		f(&c.config, cluster)
+		f(&c.config, cluster, kcp)
 	}
 
 	c.config.PublicKeyAlgorithm = x509.RSA
@@ -93,58 +94,39 @@ func createCASecret(ctx context.Context, r client.Client,
 	return nil
 }
 
-func createSASecret(ctx context.Context, r client.Client,
-	kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster,
-	name string, pub *rsa.PublicKey, key *rsa.PrivateKey) error {
-
+// lookupCA loads the cert and key of the CA from its Secret, so the leaves are always signed
+// by the CA that the components trust; it returns nil if the CA was not created yet.
+func lookupCA(ctx context.Context, r client.Client, cluster *clusterv1.Cluster, k *KinkCert) (*x509.Certificate, crypto.Signer, error) {
 	caName := types.NamespacedName{
 		Namespace: cluster.Namespace,
-		Name:      fmt.Sprintf(certNameFmt, cluster.Name, name),
+		Name:      fmt.Sprintf(certNameFmt, cluster.Name, k.Name),
 	}
 
 	sec := &v1.Secret{}
 	if err := r.Get(ctx, caName, sec); err != nil {
 		if apierrors.IsNotFound(err) {
-			sec = buildSASecret(kcp, cluster, name, pub, key)
-
-			if err = r.Create(ctx, sec); err != nil {
-				return err
-			}
-		} else {
-			return err
+			return nil, nil, nil
 		}
+		return nil, nil, err
 	}
 
-	return nil
-}
-
-func buildSASecret(kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster,
-	name string, crt *rsa.PublicKey, key *rsa.PrivateKey) *v1.Secret {
-	controllerRef := metav1.NewControllerRef(kcp,
-		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
-
-	pub, err := certs.EncodePublicKeyPEM(crt)
+	crt, err := certs.DecodeCertPEM(sec.Data[secret.TLSCrtDataName])
 	if err != nil {
-		return nil
+		return nil, nil, err
+	}
+	if crt == nil {
+		return nil, nil, errors.Errorf("no certificate found in Secret %s", caName)
 	}
 
-	sec := &v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf(certNameFmt, cluster.Name, name),
-			Namespace: cluster.Namespace,
-			Labels: map[string]string{
-				clusterv1.ClusterLabelName: cluster.Name,
-			},
-
-			OwnerReferences: []metav1.OwnerReference{*controllerRef},
-		},
-		Data: map[string][]byte{
-			secret.TLSCrtDataName: pub,
-			secret.TLSKeyDataName: certs.EncodePrivateKeyPEM(key),
-		},
-		Type: clusterv1.ClusterSecretType,
+	key, err := certs.DecodePrivateKeyPEM(sec.Data[secret.TLSKeyDataName])
+	if err != nil {
+		return nil, nil, err
 	}
-	return sec
+	if key == nil {
+		return nil, nil, errors.Errorf("no private key found in Secret %s", caName)
+	}
+
+	return crt, key, nil
 }
 
 func buildCertSecret(kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster, k *KinkCert, crt *x509.Certificate, key crypto.Signer) *v1.Secret {
@@ -171,42 +153,98 @@ func buildCertSecret(kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Clust
 
 // CreateFromCA makes and writes a certificate using the given CA cert and key.
 func (k *KinkCert) CreateFromCA(ctx context.Context, r client.Client, kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster, caCert *x509.Certificate, caKey crypto.Signer) error {
-	cfg, err := k.GetConfig(cluster)
+	cfg, err := k.GetConfig(cluster, kcp)
 	if err != nil {
 		return errors.Wrapf(err, "couldn't create %q certificate", k.Name)
 	}
 
+	certName := types.NamespacedName{
+		Namespace: cluster.Namespace,
+		Name:      fmt.Sprintf(certNameFmt, cluster.Name, k.Name),
+	}
+
+	sec := &v1.Secret{}
+	if err := r.Get(ctx, certName, sec); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		sec = nil
+	}
+
+	// The certificate is already there and still matches the desired SANs.
+	if sec != nil {
+		crt, err := certs.DecodeCertPEM(sec.Data[secret.TLSCrtDataName])
+		if err == nil && crt != nil && hasAltNames(crt, &cfg.AltNames) {
+			return nil
+		}
+	}
+
 	cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, cfg)
 	if err != nil {
 		return err
 	}
 
-	if err := createCASecret(ctx, r, kcp, cluster, k, cert, key); err != nil {
-		return errors.Wrapf(err, "failed to write or validate certificate %s/%q", cluster.Name, k.Name)
+	if sec == nil {
+		if err := createCASecret(ctx, r, kcp, cluster, k, cert, key); err != nil {
+			return errors.Wrapf(err, "failed to write or validate certificate %s/%q", cluster.Name, k.Name)
+		}
+		return nil
+	}
+
+	// Re-issue the certificate, e.g. the SANs of API Server were changed.
+	sec.Data = buildCertSecret(kcp, cluster, k, cert, key).Data
+	if err := r.Update(ctx, sec); err != nil {
+		return errors.Wrapf(err, "failed to update certificate %s/%q", cluster.Name, k.Name)
 	}
 
 	return nil
 }
 
+// hasAltNames returns true if the SANs of the certificate are exactly the given alt names.
+func hasAltNames(crt *x509.Certificate, altNames *certutil.AltNames) bool {
+	dnsNames := sets.NewString(altNames.DNSNames...)
+	if !dnsNames.Equal(sets.NewString(crt.DNSNames...)) {
+		return false
+	}
+
+	ips := sets.NewString()
+	for _, ip := range altNames.IPs {
+		ips.Insert(ip.String())
+	}
+	crtIPs := sets.NewString()
+	for _, ip := range crt.IPAddresses {
+		crtIPs.Insert(ip.String())
+	}
+
+	return ips.Equal(crtIPs)
+}
+
 // CertificateTree is represents a one-level-deep tree, mapping a CA to the certs that depend on it.
 type CertificateTree map[*KinkCert]Certificates
 
 // CreateTree creates the CAs, certs signed by the CAs, and writes them all to disk.
 func (t CertificateTree) CreateTree(ctx context.Context, r client.Client, kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster) error {
 	for ca, leaves := range t {
-		cfg, err := ca.GetConfig(cluster)
+		caCert, caKey, err := lookupCA(ctx, r, cluster, ca)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "failed to load certificate %s/%q", cluster.Name, ca.Name)
 		}
 
-		// CACert doesn't already exist, create a new cert and key.
-		caCert, caKey, err := pkiutil.NewCertificateAuthority(cfg)
-		if err != nil {
-			return err
-		}
+		if caCert == nil {
+			cfg, err := ca.GetConfig(cluster, kcp)
+			if err != nil {
+				return err
+			}
+
+			// CACert doesn't already exist, create a new cert and key.
+			caCert, caKey, err = pkiutil.NewCertificateAuthority(cfg)
+			if err != nil {
+				return err
+			}
 
-		if err := createCASecret(ctx, r, kcp, cluster, ca, caCert, caKey); err != nil {
-			return errors.Wrapf(err, "failed to write or validate certificate %s/%q", cluster.Name, ca.Name)
+			if err := createCASecret(ctx, r, kcp, cluster, ca, caCert, caKey); err != nil {
+				return errors.Wrapf(err, "failed to write or validate certificate %s/%q", cluster.Name, ca.Name)
+			}
 		}
 
 		for _, leaf := range leaves {
@@ -263,6 +301,9 @@ func GetCerts() Certificates {
 		// Front Proxy certs
 		KinkCertFrontProxyCA(),
 		KinkCertFrontProxyClient(),
+		// Client certs of the components
+		KinkCertControllerManagerClient(),
+		KinkCertSchedulerClient(),
 	}
 }
 
@@ -294,8 +335,8 @@ func KinkCertAPIServer() *KinkCert {
 			},
 		},
 		configMutators: []configMutatorsFunc{
-			func(cfg *pkiutil.CertConfig, cluster *clusterv1.Cluster) {
-				if altNames, err := getAPIServerAltNames(cluster); err == nil {
+			func(cfg *pkiutil.CertConfig, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) {
+				if altNames, err := getAPIServerAltNames(cluster, kcp); err == nil {
 					cfg.AltNames.IPs = append(cfg.AltNames.IPs, altNames.IPs...)
 					cfg.AltNames.DNSNames = append(cfg.AltNames.DNSNames, altNames.DNSNames...)
 				}
@@ -304,7 +345,7 @@ func KinkCertAPIServer() *KinkCert {
 	}
 }
 
-func getAPIServerAltNames(cluster *clusterv1.Cluster) (*certutil.AltNames, error) {
+func getAPIServerAltNames(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) (*certutil.AltNames, error) {
 	svcSubnet := "192.168.0.0/24"
 	if len(cluster.Spec.ClusterNetwork.Services.CIDRBlocks) > 0 {
 		svcSubnet = cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0]
@@ -332,6 +373,14 @@ func getAPIServerAltNames(cluster *clusterv1.Cluster) (*certutil.AltNames, error
 			fmt.Sprintf("kubernetes.default.svc.%s", cluster.Spec.ClusterNetwork.ServiceDomain))
 	}
 
+	// add the Service of the API Servers in the management cluster
+	svcName := APIServerServiceName(cluster)
+	altNames.DNSNames = append(altNames.DNSNames,
+		svcName,
+		fmt.Sprintf("%s.%s", svcName, cluster.Namespace),
+		fmt.Sprintf("%s.%s.svc", svcName, cluster.Namespace),
+	)
+
 	// add cluster controlPlaneEndpoint if present (dns or ip)
 	if cluster.Spec.ControlPlaneEndpoint.IsValid() {
 		host := cluster.Spec.ControlPlaneEndpoint.Host
@@ -342,9 +391,23 @@ func getAPIServerAltNames(cluster *clusterv1.Cluster) (*certutil.AltNames, error
 		}
 	}
 
+	// add the extra SANs of KinkControlPlane (dns or ip)
+	for _, san := range kcp.Spec.CertSANs {
+		if ip := netutils.ParseIPSloppy(san); ip != nil {
+			altNames.IPs = append(altNames.IPs, ip)
+		} else if len(san) > 0 {
+			altNames.DNSNames = append(altNames.DNSNames, san)
+		}
+	}
+
 	return altNames, nil
 }
 
+// APIServerServiceName returns the name of the Service of the API Servers.
+func APIServerServiceName(cluster *clusterv1.Cluster) string {
+	return cluster.Name + "-apiserver-svc"
+}
+
 // KinkCertKubeletClient is the definition of the cert used by the API server to access the kubelet.
 func KinkCertKubeletClient() *KinkCert {
 	return &KinkCert{
@@ -391,3 +454,35 @@ func KinkCertFrontProxyClient() *KinkCert {
 		},
 	}
 }
+
+// KinkCertControllerManagerClient is the definition of the cert used by the controller manager to access the API server.
+func KinkCertControllerManagerClient() *KinkCert {
+	return &KinkCert{
+		Name:     ControllerManagerName,
+		BaseName: ControllerManagerName,
+		LongName: "KinkCert for the controller manager to connect to the API server",
+		CAName:   "ca",
+		config: pkiutil.CertConfig{
+			Config: certutil.Config{
+				CommonName: kubeadmconstants.ControllerManagerUser,
+				Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			},
+		},
+	}
+}
+
+// KinkCertSchedulerClient is the definition of the cert used by the scheduler to access the API server.
+func KinkCertSchedulerClient() *KinkCert {
+	return &KinkCert{
+		Name:     SchedulerName,
+		BaseName: SchedulerName,
+		LongName: "KinkCert for the scheduler to connect to the API server",
+		CAName:   "ca",
+		config: pkiutil.CertConfig{
+			Config: certutil.Config{
+				CommonName: kubeadmconstants.SchedulerUser,
+				Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			},
+		},
+	}
+}
@@ -0,0 +1,503 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/version"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+)
+
+const (
+	encryptionKeyName = "encryption"
+
+	// EncryptionConfigDataName is the data of the encryption Secret that holds the
+	// EncryptionConfiguration of API Server.
+	EncryptionConfigDataName = "encryption-config.yaml"
+
+	// encryptionStateDataName is the data of the encryption Secret that holds the keys and the
+	// encrypted resources.
+	encryptionStateDataName = "state.json"
+
+	// encryptionKeySize is the size of the keys, which is valid for both aescbc and secretbox.
+	encryptionKeySize = 32
+)
+
+const (
+	// KMSSocketDir is the directory of the Unix socket shared by API Server and the KMS plugin.
+	KMSSocketDir = "/var/run/kmsplugin"
+
+	// KMSSocketPath is the Unix socket that the KMS plugin listens on.
+	KMSSocketPath = KMSSocketDir + "/socket.sock"
+)
+
+// EncryptionPollInterval is how often the API Servers are checked while a key is rotated.
+const EncryptionPollInterval = 10 * time.Second
+
+// kmsV2Version is the first version of the API Server that supports the KMS v2 API.
+var kmsV2Version = version.MustParseGeneric("v1.25.0")
+
+// encryptionConfiguration is the EncryptionConfiguration of API Server, with the apiVersion of
+// the kms provider which is missing from the vendored API.
+type encryptionConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+	Resources       []resourceConfiguration `json:"resources"`
+}
+
+type resourceConfiguration struct {
+	Resources []string                `json:"resources"`
+	Providers []providerConfiguration `json:"providers"`
+}
+
+type providerConfiguration struct {
+	apiserverconfigv1.ProviderConfiguration
+	KMS *kmsConfiguration `json:"kms,omitempty"`
+}
+
+type kmsConfiguration struct {
+	apiserverconfigv1.KMSConfiguration
+	APIVersion string `json:"apiVersion,omitempty"`
+}
+
+// encryptionKey is a key in the state of the encryption Secret.
+type encryptionKey struct {
+	ctrlv1beta1.EncryptionKey
+
+	// Secret is the base64 encoded key.
+	Secret string `json:"secret,omitempty"`
+}
+
+// encryptionState is the state of the encryption at rest, as kept in the encryption Secret.
+type encryptionState struct {
+	Resources        []string        `json:"resources,omitempty"`
+	PendingResources []string        `json:"pendingResources,omitempty"`
+	Keys             []encryptionKey `json:"keys,omitempty"`
+}
+
+// EncryptionObservedFunc checks whether all the API Servers have loaded the configuration of the
+// given state of the encryption.
+type EncryptionObservedFunc func(status *ctrlv1beta1.EncryptionStatus) (bool, error)
+
+// ReencryptFunc rewrites all the objects of the resources, so they are encrypted by the active key.
+type ReencryptFunc func(resources []string) error
+
+// EncryptionSecretName returns the name of the Secret with the EncryptionConfiguration.
+func EncryptionSecretName(cluster *clusterv1.Cluster) string {
+	return fmt.Sprintf(certNameFmt, cluster.Name, encryptionKeyName)
+}
+
+// LookupOrRotateEncryptionKeys manages the keys of the encryption at rest according to the
+// Encryption of KinkControlPlane. The keys are rotated in steps, and each step waits for all the
+// API Servers to load the configuration of the previous one:
+//  1. a new key is introduced as Pending, so every API Server can decrypt with it;
+//  2. the Pending key becomes Active to encrypt, and the previous Active key is Retired;
+//  3. the resources are re-encrypted by the Active key, and the Retired keys are removed.
+//
+// The new resources to encrypt go through the same steps. It returns the state of the encryption,
+// and when it has to be checked again.
+func (c *CertificatesManager) LookupOrRotateEncryptionKeys(observed EncryptionObservedFunc, reencrypt ReencryptFunc) (*ctrlv1beta1.EncryptionStatus, time.Duration, error) {
+	secName := types.NamespacedName{
+		Namespace: c.cluster.Namespace,
+		Name:      EncryptionSecretName(c.cluster),
+	}
+
+	sec := &v1.Secret{}
+	if err := c.r.Get(c.ctx, secName, sec); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, 0, err
+		}
+		sec = nil
+	}
+
+	state := &encryptionState{}
+	if sec != nil {
+		if err := json.Unmarshal(sec.Data[encryptionStateDataName], state); err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to load encryption keys of %s", secName)
+		}
+	}
+
+	spec := c.kcp.Spec.Encryption
+	if spec == nil && len(state.Keys) == 0 {
+		return nil, 0, nil
+	}
+
+	provider, resources, rotationPeriod := getEncryptionSettings(spec)
+	now := time.Now()
+
+	isObserved := false
+	if len(state.Keys) > 0 {
+		var err error
+		if isObserved, err = observed(state.status()); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var newResources []string
+	if spec != nil {
+		encrypted := sets.NewString(state.Resources...).Insert(state.PendingResources...)
+		for _, res := range resources {
+			if !encrypted.Has(res) {
+				newResources = append(newResources, res)
+			}
+		}
+	}
+
+	changed := true
+	switch {
+	case len(state.Keys) == 0:
+		key, err := newEncryptionKey(c.kcp, provider, now)
+		if err != nil {
+			return nil, 0, err
+		}
+		state.Keys = append(state.Keys, *key)
+		state.Resources = resources
+
+	case !isObserved:
+		// Wait for all the API Servers to load the current configuration.
+		changed = false
+
+	case state.hasKey(ctrlv1beta1.EncryptionKeyPending):
+		hasActive := state.hasKey(ctrlv1beta1.EncryptionKeyActive)
+		for i := range state.Keys {
+			switch state.Keys[i].State {
+			case ctrlv1beta1.EncryptionKeyActive:
+				state.Keys[i].State = ctrlv1beta1.EncryptionKeyRetired
+			case ctrlv1beta1.EncryptionKeyPending:
+				state.Keys[i].State = ctrlv1beta1.EncryptionKeyActive
+			}
+		}
+		if !hasActive {
+			// The resources were written in plaintext before the first key.
+			state.retirePlaintext(now)
+		}
+
+	case len(state.PendingResources) > 0:
+		state.Resources = append(state.Resources, state.PendingResources...)
+		state.PendingResources = nil
+		state.retirePlaintext(now)
+
+	case state.hasKey(ctrlv1beta1.EncryptionKeyRetired):
+		if err := reencrypt(state.Resources); err != nil {
+			return nil, 0, errors.Wrap(err, "failed to re-encrypt resources")
+		}
+
+		var keys []encryptionKey
+		for _, k := range state.Keys {
+			if k.State != ctrlv1beta1.EncryptionKeyRetired {
+				keys = append(keys, k)
+			}
+		}
+		state.Keys = keys
+
+	case len(newResources) > 0:
+		// The new resources are read in plaintext, until all the API Servers encrypt them.
+		state.PendingResources = newResources
+
+	case spec != nil && state.needsRotation(spec, provider, rotationPeriod, now):
+		key, err := newEncryptionKey(c.kcp, provider, now)
+		if err != nil {
+			return nil, 0, err
+		}
+		state.Keys = append(state.Keys, *key)
+
+	default:
+		changed = false
+	}
+
+	if changed {
+		data, err := buildEncryptionData(state)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if sec == nil {
+			if err := c.r.Create(c.ctx, buildKeySecret(c.kcp, c.cluster, encryptionKeyName, data)); err != nil {
+				return nil, 0, err
+			}
+		} else {
+			sec.Data = data
+			if err := c.r.Update(c.ctx, sec); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	var requeueAfter time.Duration
+	if changed || !isObserved || state.inProgress() {
+		requeueAfter = EncryptionPollInterval
+	} else if active := state.activeKey(); active != nil && active.Provider != ctrlv1beta1.KMSEncryption && rotationPeriod > 0 {
+		requeueAfter = active.CreatedAt.Add(rotationPeriod).Sub(now)
+	}
+
+	return state.status(), requeueAfter, nil
+}
+
+func getEncryptionSettings(spec *ctrlv1beta1.EncryptionSpec) (ctrlv1beta1.EncryptionProvider, []string, time.Duration) {
+	provider := ctrlv1beta1.AESCBCEncryption
+	resources := []string{"secrets"}
+	var rotationPeriod time.Duration
+
+	if spec != nil {
+		if len(spec.Provider) > 0 {
+			provider = spec.Provider
+		}
+		if len(spec.Resources) > 0 {
+			resources = spec.Resources
+		}
+		if spec.KeyRotationPeriod != nil {
+			rotationPeriod = spec.KeyRotationPeriod.Duration
+		}
+	}
+
+	return provider, resources, rotationPeriod
+}
+
+func newEncryptionKey(kcp *ctrlv1beta1.KinkControlPlane, provider ctrlv1beta1.EncryptionProvider, createdAt time.Time) (*encryptionKey, error) {
+	// The key of the kms provider is kept by the KMS, it is named after the plugin.
+	if provider == ctrlv1beta1.KMSEncryption {
+		spec := kcp.Spec.Encryption
+		if spec == nil || spec.KMS == nil {
+			return nil, errors.New("the KMS plugin is required by the kms provider")
+		}
+
+		apiVersion, err := getKMSAPIVersion(kcp)
+		if err != nil {
+			return nil, err
+		}
+
+		return &encryptionKey{
+			EncryptionKey: ctrlv1beta1.EncryptionKey{
+				Name:          spec.KMS.Name,
+				Provider:      provider,
+				State:         ctrlv1beta1.EncryptionKeyPending,
+				CreatedAt:     metav1.Time{Time: createdAt},
+				KMSAPIVersion: apiVersion,
+			},
+		}, nil
+	}
+
+	secret := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return &encryptionKey{
+		EncryptionKey: ctrlv1beta1.EncryptionKey{
+			Name:      fmt.Sprintf("key-%d", createdAt.Unix()),
+			Provider:  provider,
+			State:     ctrlv1beta1.EncryptionKeyPending,
+			CreatedAt: metav1.Time{Time: createdAt},
+		},
+		Secret: base64.StdEncoding.EncodeToString(secret),
+	}, nil
+}
+
+// getKMSAPIVersion returns the version of the KMS API served by the KMS plugin; KMS v2 is only
+// allowed if both the version of KinkControlPlane and the minimum version that its KinkMachines
+// run support it, as the API Servers of the older KinkMachines would reject the configuration.
+func getKMSAPIVersion(kcp *ctrlv1beta1.KinkControlPlane) (ctrlv1beta1.KMSAPIVersion, error) {
+	apiVersion := kcp.Spec.Encryption.KMS.APIVersion
+	if len(apiVersion) == 0 || apiVersion == ctrlv1beta1.KMSAPIVersionV1 {
+		return ctrlv1beta1.KMSAPIVersionV1, nil
+	}
+
+	supported := func(ver *string) bool {
+		v, err := version.ParseGeneric(pointer.StringDeref(ver, ""))
+		return err == nil && v.AtLeast(kmsV2Version)
+	}
+	if !supported(kcp.Spec.Version) {
+		return "", errors.Errorf("KMS %s requires the version of KinkControlPlane to be v%s or later", apiVersion, kmsV2Version)
+	}
+	if kcp.Status.Version != nil && !supported(kcp.Status.Version) {
+		return "", errors.Errorf("KMS %s requires all the KinkMachines to run v%s or later, the oldest runs %s",
+			apiVersion, kmsV2Version, *kcp.Status.Version)
+	}
+
+	return apiVersion, nil
+}
+
+func (s *encryptionState) hasKey(state ctrlv1beta1.EncryptionKeyState) bool {
+	for _, k := range s.Keys {
+		if k.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *encryptionState) activeKey() *encryptionKey {
+	for i := range s.Keys {
+		if s.Keys[i].State == ctrlv1beta1.EncryptionKeyActive {
+			return &s.Keys[i]
+		}
+	}
+	return nil
+}
+
+// retirePlaintext records that some resources are in plaintext, so they are re-encrypted.
+func (s *encryptionState) retirePlaintext(now time.Time) {
+	for _, k := range s.Keys {
+		if k.Provider == ctrlv1beta1.IdentityEncryption {
+			return
+		}
+	}
+
+	s.Keys = append(s.Keys, encryptionKey{
+		EncryptionKey: ctrlv1beta1.EncryptionKey{
+			Name:      string(ctrlv1beta1.IdentityEncryption),
+			Provider:  ctrlv1beta1.IdentityEncryption,
+			State:     ctrlv1beta1.EncryptionKeyRetired,
+			CreatedAt: metav1.Time{Time: now},
+		},
+	})
+}
+
+func (s *encryptionState) needsRotation(spec *ctrlv1beta1.EncryptionSpec, provider ctrlv1beta1.EncryptionProvider,
+	rotationPeriod time.Duration, now time.Time) bool {
+	active := s.activeKey()
+	if active == nil {
+		return false
+	}
+
+	if active.Provider != provider {
+		return true
+	}
+
+	if provider == ctrlv1beta1.KMSEncryption {
+		return spec.KMS != nil && active.Name != spec.KMS.Name
+	}
+
+	return rotationPeriod > 0 && now.Sub(active.CreatedAt.Time) >= rotationPeriod
+}
+
+func (s *encryptionState) inProgress() bool {
+	return len(s.PendingResources) > 0 ||
+		s.hasKey(ctrlv1beta1.EncryptionKeyPending) ||
+		s.hasKey(ctrlv1beta1.EncryptionKeyRetired)
+}
+
+func (s *encryptionState) status() *ctrlv1beta1.EncryptionStatus {
+	status := &ctrlv1beta1.EncryptionStatus{
+		Resources:        s.Resources,
+		PendingResources: s.PendingResources,
+	}
+	for _, k := range s.Keys {
+		status.Keys = append(status.Keys, k.EncryptionKey)
+	}
+
+	return status
+}
+
+// buildEncryptionData builds the data of the encryption Secret. The Active key encrypts the
+// resources, or they are written in plaintext until there is an Active key; the pending resources
+// are always written in plaintext.
+func buildEncryptionData(state *encryptionState) (map[string][]byte, error) {
+	var active, others []providerConfiguration
+	for _, k := range state.Keys {
+		key := []apiserverconfigv1.Key{{Name: k.Name, Secret: k.Secret}}
+
+		var p providerConfiguration
+		switch k.Provider {
+		case ctrlv1beta1.AESCBCEncryption:
+			p.AESCBC = &apiserverconfigv1.AESConfiguration{Keys: key}
+		case ctrlv1beta1.SecretboxEncryption:
+			p.Secretbox = &apiserverconfigv1.SecretboxConfiguration{Keys: key}
+		case ctrlv1beta1.KMSEncryption:
+			p.KMS = &kmsConfiguration{
+				KMSConfiguration: apiserverconfigv1.KMSConfiguration{
+					Name:     k.Name,
+					Endpoint: "unix://" + KMSSocketPath,
+				},
+			}
+			if k.KMSAPIVersion == ctrlv1beta1.KMSAPIVersionV2 {
+				p.KMS.APIVersion = string(ctrlv1beta1.KMSAPIVersionV2)
+			}
+		default:
+			// The identity provider is always appended.
+			continue
+		}
+
+		if k.State == ctrlv1beta1.EncryptionKeyActive {
+			active = append(active, p)
+		} else {
+			others = append(others, p)
+		}
+	}
+
+	identity := providerConfiguration{}
+	identity.Identity = &apiserverconfigv1.IdentityConfiguration{}
+
+	var providers []providerConfiguration
+	if len(active) > 0 {
+		providers = append(append(active, others...), identity)
+	} else {
+		providers = append([]providerConfiguration{identity}, others...)
+	}
+
+	config := &encryptionConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiserverconfigv1.SchemeGroupVersion.String(),
+			Kind:       "EncryptionConfiguration",
+		},
+		Resources: []resourceConfiguration{
+			{
+				Resources: state.Resources,
+				Providers: providers,
+			},
+		},
+	}
+
+	if len(state.PendingResources) > 0 {
+		config.Resources = append(config.Resources, resourceConfiguration{
+			Resources: state.PendingResources,
+			Providers: append(append([]providerConfiguration{identity}, active...), others...),
+		})
+	}
+
+	configData, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	stateData, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		EncryptionConfigDataName: configData,
+		encryptionStateDataName:  stateData,
+	}, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+
+	"sigs.k8s.io/cluster-api/util/secret"
+)
+
+const (
+	// ControllerManagerName is the name of the cert and kubeconfig of the controller manager.
+	ControllerManagerName = "controller-manager"
+
+	// SchedulerName is the name of the cert and kubeconfig of the scheduler.
+	SchedulerName = "scheduler"
+)
+
+// componentUsers are the users of the components that access API Server with a kubeconfig.
+var componentUsers = map[string]string{
+	ControllerManagerName: kubeadmconstants.ControllerManagerUser,
+	SchedulerName:         kubeadmconstants.SchedulerUser,
+}
+
+// KubeconfigSecretName returns the name of the kubeconfig Secret of a component.
+func KubeconfigSecretName(clusterName, name string) string {
+	return fmt.Sprintf(certNameFmt, clusterName, name+"-kubeconfig")
+}
+
+// LookupOrGenerateComponentKubeconfigs writes the kubeconfigs of the controller manager and the
+// scheduler, with their client certs; the kubeconfigs are rewritten when the certs or the
+// control plane endpoint are changed.
+func (c *CertificatesManager) LookupOrGenerateComponentKubeconfigs() error {
+	caCrt, err := c.lookupCertData("ca", secret.TLSCrtDataName)
+	if err != nil {
+		return err
+	}
+
+	for name, user := range componentUsers {
+		crt, err := c.lookupCertData(name, secret.TLSCrtDataName)
+		if err != nil {
+			return err
+		}
+		key, err := c.lookupCertData(name, secret.TLSKeyDataName)
+		if err != nil {
+			return err
+		}
+
+		kubeconfig, err := buildKubeconfig(c.cluster.Name, user,
+			"https://"+c.cluster.Spec.ControlPlaneEndpoint.String(), caCrt, crt, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to build kubeconfig of %s", name)
+		}
+
+		secName := types.NamespacedName{
+			Namespace: c.cluster.Namespace,
+			Name:      KubeconfigSecretName(c.cluster.Name, name),
+		}
+
+		sec := &v1.Secret{}
+		if err := c.r.Get(c.ctx, secName, sec); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			data := map[string][]byte{secret.KubeconfigDataName: kubeconfig}
+			if err := c.r.Create(c.ctx, buildKeySecret(c.kcp, c.cluster, name+"-kubeconfig", data)); err != nil {
+				return errors.Wrapf(err, "failed to create kubeconfig of %s", name)
+			}
+			continue
+		}
+
+		if !bytes.Equal(sec.Data[secret.KubeconfigDataName], kubeconfig) {
+			sec.Data = map[string][]byte{secret.KubeconfigDataName: kubeconfig}
+			if err := c.r.Update(c.ctx, sec); err != nil {
+				return errors.Wrapf(err, "failed to update kubeconfig of %s", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *CertificatesManager) lookupCertData(name, dataName string) ([]byte, error) {
+	secName := types.NamespacedName{
+		Namespace: c.cluster.Namespace,
+		Name:      fmt.Sprintf(certNameFmt, c.cluster.Name, name),
+	}
+
+	sec := &v1.Secret{}
+	if err := c.r.Get(c.ctx, secName, sec); err != nil {
+		return nil, err
+	}
+
+	data, found := sec.Data[dataName]
+	if !found {
+		return nil, errors.Errorf("no %s found in Secret %s", dataName, secName)
+	}
+
+	return data, nil
+}
+
+func buildKubeconfig(clusterName, user, server string, caCrt, crt, key []byte) ([]byte, error) {
+	contextName := fmt.Sprintf("%s@%s", user, clusterName)
+
+	return clientcmd.Write(clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			clusterName: {
+				Server:                   server,
+				CertificateAuthorityData: caCrt,
+			},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			user: {
+				ClientCertificateData: crt,
+				ClientKeyData:         key,
+			},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			contextName: {
+				Cluster:  clusterName,
+				AuthInfo: user,
+			},
+		},
+		CurrentContext: contextName,
+	})
+}
@@ -18,14 +18,11 @@ package secrets
 
 import (
 	"context"
-	"crypto/rsa"
-	"crypto/x509"
 
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
 	"sigs.k8s.io/cluster-api/util/kubeconfig"
@@ -68,19 +65,6 @@ func (c *CertificatesManager) LookupOrGenerateCAs() error {
 		return err
 	}
 
-	// generate sa-ca private/public key
-	// The key does NOT exist, let's generate it now
-	key, err := pkiutil.NewPrivateKey(x509.RSA)
-	if err != nil {
-		return err
-	}
-
-	pub := key.Public()
-	if err = createSASecret(c.ctx, c.r, c.kcp, c.cluster,
-		"sa", pub.(*rsa.PublicKey), key.(*rsa.PrivateKey)); err != nil {
-		return err
-	}
-
 	return nil
 }
 
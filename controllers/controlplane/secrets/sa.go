@@ -0,0 +1,594 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+const (
+	saKeyName = "sa"
+
+	// SAPublicKeysDataName is the data of the service account Secret that holds the public keys
+	// of all the signing keys in the verification set.
+	SAPublicKeysDataName = "sa.pub"
+
+	// saKeyHistoryDataName is the data of the service account Secret that holds the history of
+	// the signing keys.
+	saKeyHistoryDataName = "history.json"
+
+	// saPendingKeyDataName is the data of the service account Secret that holds the private key
+	// of the pending signing key; it is not mounted into the pods until the key is active.
+	saPendingKeyDataName = "pending.key"
+
+	// SAKeyIDsAnnotationName records the service account keys in the verification set that the
+	// API Server pod was started with, so the pod is rolled to load the keys when they are
+	// rotated, and the pending key is activated once all the API Servers have it.
+	SAKeyIDsAnnotationName = "kink.openbce.io/sa-keys"
+
+	// SASigningKeyIDAnnotationName records the service account key that the pod signs the tokens
+	// with, so the pod is rolled to load the key when it is activated.
+	SASigningKeyIDAnnotationName = "kink.openbce.io/sa-signing-key"
+
+	// saKeyActivationCheckPeriod is how often the pending key is checked for activation.
+	saKeyActivationCheckPeriod = 30 * time.Second
+
+	// DefaultMaxTokenExpiration is how long a retired signing key is kept by default.
+	DefaultMaxTokenExpiration = 24 * time.Hour
+)
+
+// saKey is a signing key in the history of the service account Secret.
+type saKey struct {
+	ctrlv1beta1.ServiceAccountKey
+
+	// PublicKey is the PEM encoded public key.
+	PublicKey string `json:"publicKey"`
+}
+
+// LookupOrRotateSAKeys generates the service account signing key if it does not exist, and
+// rotates it according to the ServiceAccount of KinkControlPlane. The rotation is staged: a new
+// key is added to the verification set as pending when the active one is older than the rotation
+// period, and it signs the tokens only once every API Server was started with it, so the tokens
+// signed by it are verified by all of them; the retired keys are kept in the verification set
+// until the tokens signed by them expire. It returns the keys in the verification set, and when
+// the keys have to be checked again.
+func (c *CertificatesManager) LookupOrRotateSAKeys() ([]ctrlv1beta1.ServiceAccountKey, time.Duration, error) {
+	saName := types.NamespacedName{
+		Namespace: c.cluster.Namespace,
+		Name:      fmt.Sprintf(certNameFmt, c.cluster.Name, saKeyName),
+	}
+
+	sec := &v1.Secret{}
+	if err := c.r.Get(c.ctx, saName, sec); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, 0, err
+		}
+		sec = nil
+	}
+
+	var history []saKey
+	changed := false
+	if sec != nil {
+		h, migrated, err := loadSAKeyHistory(sec)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to load service account keys of %s", saName)
+		}
+		history, changed = h, migrated
+	}
+
+	rotationPeriod, maxTokenExpiration := getSAKeyDurations(c.kcp)
+	now := time.Now()
+
+	var activeKey, pendingKey *rsa.PrivateKey
+	switch {
+	case len(history) == 0:
+		// The first key signs the tokens right away, no API Server runs before it.
+		key, newKey, err := generateSAKey(now)
+		if err != nil {
+			return nil, 0, err
+		}
+		activeKey = key
+		history = append(history, *newKey)
+		changed = true
+
+	case history[len(history)-1].Pending:
+		pending := &history[len(history)-1]
+		trusted, err := c.isSAKeyTrusted(pending.KeyID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !trusted {
+			break
+		}
+
+		key, err := parseRSAPrivateKey(sec.Data[saPendingKeyDataName])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to load the pending service account key of %s", saName)
+		}
+		activeKey = key
+
+		for i := range history[:len(history)-1] {
+			if history[i].RetiredAt == nil {
+				history[i].RetiredAt = &metav1.Time{Time: now}
+			}
+		}
+		pending.Pending = false
+		changed = true
+
+	case rotationPeriod > 0 && now.Sub(history[len(history)-1].CreatedAt.Time) >= rotationPeriod:
+		key, newKey, err := generateSAKey(now)
+		if err != nil {
+			return nil, 0, err
+		}
+		pendingKey = key
+		newKey.Pending = true
+		history = append(history, *newKey)
+		changed = true
+	}
+
+	// Prune the retired keys once the tokens signed by them are expired.
+	var keys []saKey
+	for _, k := range history {
+		if k.RetiredAt != nil && now.Sub(k.RetiredAt.Time) >= maxTokenExpiration {
+			changed = true
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	if changed {
+		data, err := buildSAKeyData(keys, activeKey, pendingKey, sec)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if sec == nil {
+			if err := c.r.Create(c.ctx, buildKeySecret(c.kcp, c.cluster, saKeyName, data)); err != nil {
+				return nil, 0, err
+			}
+		} else {
+			sec.Data = data
+			if err := c.r.Update(c.ctx, sec); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	var res []ctrlv1beta1.ServiceAccountKey
+	var requeueAfter time.Duration
+	for _, k := range keys {
+		res = append(res, k.ServiceAccountKey)
+
+		var next time.Duration
+		if k.RetiredAt != nil {
+			next = k.RetiredAt.Add(maxTokenExpiration).Sub(now)
+		} else if k.Pending {
+			next = saKeyActivationCheckPeriod
+		} else if rotationPeriod > 0 {
+			next = k.CreatedAt.Add(rotationPeriod).Sub(now)
+		}
+		if next > 0 && (requeueAfter == 0 || next < requeueAfter) {
+			requeueAfter = next
+		}
+	}
+
+	return res, requeueAfter, nil
+}
+
+// LookupSAPublicKeys returns the public keys of the signing keys in the verification set.
+func (c *CertificatesManager) LookupSAPublicKeys() ([]interface{}, error) {
+	saName := types.NamespacedName{
+		Namespace: c.cluster.Namespace,
+		Name:      fmt.Sprintf(certNameFmt, c.cluster.Name, saKeyName),
+	}
+
+	sec := &v1.Secret{}
+	if err := c.r.Get(c.ctx, saName, sec); err != nil {
+		return nil, err
+	}
+
+	return keyutil.ParsePublicKeysPEM(sec.Data[SAPublicKeysDataName])
+}
+
+// BuildOIDCDiscovery builds the OpenID Connect discovery document and the JSON Web Key Set of the
+// service account issuer, as served by API Server at /.well-known/openid-configuration and
+// /openid/v1/jwks.
+func (c *CertificatesManager) BuildOIDCDiscovery(issuer, jwksURI string) ([]byte, []byte, error) {
+	pubs, err := c.LookupSAPublicKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type jwk struct {
+		Use string `json:"use"`
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+
+	keys := []jwk{}
+	for _, pub := range pubs {
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, nil, errors.Errorf("unexpected type %T of service account public key", pub)
+		}
+
+		kid, err := keyIDFromPublicKey(rsaPub)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, jwk{
+			Use: "sig",
+			Kty: "RSA",
+			Kid: kid,
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(rsaPub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaPub.E)).Bytes()),
+		})
+	}
+
+	jwks, err := json.Marshal(struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: keys})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := json.Marshal(struct {
+		Issuer        string   `json:"issuer"`
+		JWKSURI       string   `json:"jwks_uri"`
+		ResponseTypes []string `json:"response_types_supported"`
+		SubjectTypes  []string `json:"subject_types_supported"`
+		SigningAlgs   []string `json:"id_token_signing_alg_values_supported"`
+	}{
+		Issuer:        issuer,
+		JWKSURI:       jwksURI,
+		ResponseTypes: []string{"id_token"},
+		SubjectTypes:  []string{"public"},
+		SigningAlgs:   []string{"RS256"},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, jwks, nil
+}
+
+// GetServiceAccountIssuer returns the issuer of the service account tokens.
+func GetServiceAccountIssuer(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) string {
+	if sa := kcp.Spec.ServiceAccount; sa != nil && len(sa.Issuer) > 0 {
+		return sa.Issuer
+	}
+
+	serviceDomain := "cluster.local"
+	if cluster.Spec.ClusterNetwork != nil && len(cluster.Spec.ClusterNetwork.ServiceDomain) > 0 {
+		serviceDomain = cluster.Spec.ClusterNetwork.ServiceDomain
+	}
+
+	return fmt.Sprintf("https://kubernetes.default.svc.%s", serviceDomain)
+}
+
+// UpdateServiceAccountIssuers records the issuer of KinkControlPlane as the current one, and keeps
+// the previous issuers accepted until the tokens issued by them are expired, so changing the
+// issuer or the service domain of the cluster does not invalidate the tokens in use. It returns
+// when to prune the next retired issuer.
+func UpdateServiceAccountIssuers(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) ([]ctrlv1beta1.ServiceAccountIssuer, time.Duration) {
+	now := time.Now()
+	_, maxTokenExpiration := getSAKeyDurations(kcp)
+	current := GetServiceAccountIssuer(cluster, kcp)
+
+	var res []ctrlv1beta1.ServiceAccountIssuer
+	var requeueAfter time.Duration
+	for _, i := range kcp.Status.ServiceAccountIssuers {
+		if i.Issuer == current {
+			continue
+		}
+		if i.RetiredAt == nil {
+			i.RetiredAt = &metav1.Time{Time: now}
+		}
+
+		next := i.RetiredAt.Add(maxTokenExpiration).Sub(now)
+		if next <= 0 {
+			continue
+		}
+		if requeueAfter == 0 || next < requeueAfter {
+			requeueAfter = next
+		}
+		res = append(res, i)
+	}
+
+	return append(res, ctrlv1beta1.ServiceAccountIssuer{Issuer: current}), requeueAfter
+}
+
+// GetServiceAccountJWKSURI returns the URI of the JSON Web Key Set of the issuer; it is empty if
+// the default of API Server is used.
+func GetServiceAccountJWKSURI(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) string {
+	sa := kcp.Spec.ServiceAccount
+	if sa == nil {
+		return ""
+	}
+
+	if len(sa.JWKSURI) > 0 {
+		return sa.JWKSURI
+	}
+
+	if sa.Discovery != nil {
+		switch sa.Discovery.Mode {
+		case ctrlv1beta1.APIServerDiscovery:
+			// The API Server advertises its pod address by default, which is not reachable publicly.
+			return fmt.Sprintf("https://%s/openid/v1/jwks", cluster.Spec.ControlPlaneEndpoint.String())
+		case ctrlv1beta1.ConfigMapDiscovery:
+			return strings.TrimSuffix(GetServiceAccountIssuer(cluster, kcp), "/") + "/openid/v1/jwks"
+		}
+	}
+
+	return ""
+}
+
+func getSAKeyDurations(kcp *ctrlv1beta1.KinkControlPlane) (time.Duration, time.Duration) {
+	var rotationPeriod time.Duration
+	maxTokenExpiration := DefaultMaxTokenExpiration
+
+	if sa := kcp.Spec.ServiceAccount; sa != nil {
+		if sa.KeyRotationPeriod != nil {
+			rotationPeriod = sa.KeyRotationPeriod.Duration
+		}
+		if sa.MaxTokenExpiration != nil {
+			maxTokenExpiration = sa.MaxTokenExpiration.Duration
+		}
+	}
+
+	return rotationPeriod, maxTokenExpiration
+}
+
+// loadSAKeyHistory loads the history of the signing keys from the Secret; the Secret created
+// before the rotation was supported only has the key pair, which becomes the first key of the
+// history.
+func loadSAKeyHistory(sec *v1.Secret) ([]saKey, bool, error) {
+	if data, found := sec.Data[saKeyHistoryDataName]; found {
+		var history []saKey
+		if err := json.Unmarshal(data, &history); err != nil {
+			return nil, false, err
+		}
+		return history, false, nil
+	}
+
+	pubs, err := keyutil.ParsePublicKeysPEM(sec.Data[secret.TLSCrtDataName])
+	if err != nil {
+		return nil, false, err
+	}
+
+	pub, ok := pubs[0].(*rsa.PublicKey)
+	if !ok {
+		return nil, false, errors.Errorf("unexpected type %T of service account public key", pubs[0])
+	}
+
+	k, err := newSAKey(pub, sec.CreationTimestamp.Time)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []saKey{*k}, true, nil
+}
+
+// generateSAKey generates a new signing key.
+func generateSAKey(createdAt time.Time) (*rsa.PrivateKey, *saKey, error) {
+	key, err := pkiutil.NewPrivateKey(x509.RSA)
+	if err != nil {
+		return nil, nil, err
+	}
+	rsaKey := key.(*rsa.PrivateKey)
+
+	k, err := newSAKey(&rsaKey.PublicKey, createdAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rsaKey, k, nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	key, err := keyutil.ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("unexpected type %T of service account private key", key)
+	}
+
+	return rsaKey, nil
+}
+
+// isSAKeyTrusted returns true if all the API Server pods of the cluster were started with the key
+// in the verification set, and at least one of them is ready; with no API Server serving yet, e.g.
+// while all of them are restarting, nothing proves the key is verified.
+func (c *CertificatesManager) isSAKeyTrusted(keyID string) (bool, error) {
+	pods := &v1.PodList{}
+	if err := c.r.List(c.ctx, pods,
+		client.InNamespace(c.cluster.Namespace),
+		client.MatchingLabels{
+			clusterv1.ClusterLabelName:             c.cluster.Name,
+			infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+		},
+	); err != nil {
+		return false, errors.Wrap(err, "failed to list API Server pods")
+	}
+
+	ready := false
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		trusted := false
+		for _, id := range strings.Split(pod.Annotations[SAKeyIDsAnnotationName], ",") {
+			if id == keyID {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return false, nil
+		}
+
+		ready = ready || isPodReady(pod)
+	}
+
+	return ready, nil
+}
+
+// isPodReady returns true if the pod is running and all its containers are ready.
+func isPodReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if !status.Ready {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newSAKey(pub *rsa.PublicKey, createdAt time.Time) (*saKey, error) {
+	pem, err := certs.EncodePublicKeyPEM(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := keyIDFromPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saKey{
+		ServiceAccountKey: ctrlv1beta1.ServiceAccountKey{
+			KeyID:     keyID,
+			CreatedAt: metav1.Time{Time: createdAt},
+		},
+		PublicKey: string(pem),
+	}, nil
+}
+
+// keyIDFromPublicKey derives the key ID the same way as kube-apiserver does for the "kid"
+// header of the tokens.
+func keyIDFromPublicKey(pub interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// buildSAKeyData builds the data of the service account Secret; the key pair of the active key
+// is kept as is if the active key was not changed, and so is the pending key if no key was
+// activated or added.
+func buildSAKeyData(keys []saKey, activeKey, pendingKey *rsa.PrivateKey, sec *v1.Secret) (map[string][]byte, error) {
+	history, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var pubs []byte
+	for _, k := range keys {
+		pubs = append(pubs, []byte(k.PublicKey)...)
+	}
+
+	data := map[string][]byte{
+		SAPublicKeysDataName: pubs,
+		saKeyHistoryDataName: history,
+	}
+
+	if activeKey != nil {
+		pub, err := certs.EncodePublicKeyPEM(&activeKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		data[secret.TLSCrtDataName] = pub
+		data[secret.TLSKeyDataName] = certs.EncodePrivateKeyPEM(activeKey)
+	} else {
+		data[secret.TLSCrtDataName] = sec.Data[secret.TLSCrtDataName]
+		data[secret.TLSKeyDataName] = sec.Data[secret.TLSKeyDataName]
+	}
+
+	switch {
+	case pendingKey != nil:
+		data[saPendingKeyDataName] = certs.EncodePrivateKeyPEM(pendingKey)
+	case activeKey == nil:
+		if pending, found := sec.Data[saPendingKeyDataName]; found {
+			data[saPendingKeyDataName] = pending
+		}
+	}
+
+	return data, nil
+}
+
+func buildKeySecret(kcp *ctrlv1beta1.KinkControlPlane, cluster *clusterv1.Cluster,
+	name string, data map[string][]byte) *v1.Secret {
+	controllerRef := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
+
+	sec := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf(certNameFmt, cluster.Name, name),
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				clusterv1.ClusterLabelName: cluster.Name,
+			},
+
+			OwnerReferences: []metav1.OwnerReference{*controllerRef},
+		},
+		Data: data,
+		Type: clusterv1.ClusterSecretType,
+	}
+	return sec
+}
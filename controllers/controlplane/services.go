@@ -0,0 +1,135 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// lookupOrSetupServices exposes the etcd and API Server pods of all the KinkMachines by the
+// Services of the cluster. The Services are controlled by KinkControlPlane, so they outlive the
+// KinkMachines removed on scale-down or remediation; the ones created by a KinkMachine before
+// are taken over. The type of the Services follows KinkControlPlane, but their ports are kept
+// once created, so the allocated node ports are not changed.
+func (r *KinkControlPlaneReconciler) lookupOrSetupServices(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	svcs := map[infrav1beta1.ControlPlaneRole]*v1.Service{
+		infrav1beta1.ETCD:      templates.EtcdServiceTemplate(cluster, kcp),
+		infrav1beta1.ApiServer: templates.ApiServerServiceTemplate(cluster, kcp),
+	}
+
+	for _, role := range infrav1beta1.ControlPlaneRoles {
+		desired, found := svcs[role]
+		if !found {
+			continue
+		}
+
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      desired.Name,
+				Namespace: desired.Namespace,
+			},
+		}
+
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+			svc.Labels = desired.Labels
+			svc.Spec.Selector = desired.Spec.Selector
+			svc.Spec.Type = desired.Spec.Type
+			if svc.CreationTimestamp.IsZero() {
+				svc.Spec.Ports = desired.Spec.Ports
+			}
+
+			svc.OwnerReferences = removeMachineOwners(svc.OwnerReferences)
+			return controllerutil.SetControllerReference(kcp, svc, r.Scheme)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to setup the Service of %s", role)
+		}
+	}
+
+	return nil
+}
+
+// keepHostNetwork records whether the hardened control plane pods stay on the host network: they
+// do if the API Server pods already run there, i.e. they were created before the hardened pods
+// moved to the pod network, so the endpoints of the cluster, e.g. the addresses of the hosts, keep
+// working. It is recorded once, so the pods move to the pod network once the annotation is
+// changed.
+func (r *KinkControlPlaneReconciler) keepHostNetwork(ctx context.Context, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) error {
+	logger := log.FromContext(ctx)
+
+	if _, found := kcp.Annotations[ctrlv1beta1.HostNetworkAnnotationName]; found {
+		return nil
+	}
+
+	pods := &v1.PodList{}
+	if err := r.List(ctx, pods,
+		client.InNamespace(cluster.Namespace),
+		client.MatchingLabels{
+			clusterv1.ClusterLabelName:             cluster.Name,
+			infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+		},
+	); err != nil {
+		return errors.Wrap(err, "failed to list API Server pods")
+	}
+
+	hostNetwork := false
+	privileged := kcp.Spec.PodSecurity != nil && kcp.Spec.PodSecurity.Privileged
+	for i := range pods.Items {
+		if pods.Items[i].Spec.HostNetwork && !privileged {
+			hostNetwork = true
+			break
+		}
+	}
+
+	if kcp.Annotations == nil {
+		kcp.Annotations = map[string]string{}
+	}
+	kcp.Annotations[ctrlv1beta1.HostNetworkAnnotationName] = strconv.FormatBool(hostNetwork)
+	if hostNetwork {
+		logger.Info("Keep the control plane pods on the host network", "KinkControlPlane", kcp.Name)
+	}
+
+	return nil
+}
+
+// removeMachineOwners drops the KinkMachines from the owners of the Service, so it is not
+// garbage collected with them.
+func removeMachineOwners(refs []metav1.OwnerReference) []metav1.OwnerReference {
+	var res []metav1.OwnerReference
+	for _, ref := range refs {
+		if ref.Kind == "KinkMachine" && ref.APIVersion == infrav1beta1.GroupVersion.String() {
+			continue
+		}
+		res = append(res, ref)
+	}
+
+	return res
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// APIServerDrainDelay is how long an API Server pod is out of the endpoints of the API Server
+// Service before it is deleted, so the load balancers stop sending new connections to it.
+const APIServerDrainDelay = 15 * time.Second
+
+// DrainAPIServerPod takes the API Server pod out of the endpoints of the API Server Service, and
+// returns how long to wait before the pod can be deleted; it returns zero for the other pods.
+func DrainAPIServerPod(ctx context.Context, c client.Client, pod *v1.Pod) (time.Duration, error) {
+	if pod.Labels[infrav1beta1.ControlPlaneRoleLabelName] != string(infrav1beta1.ApiServer) {
+		return 0, nil
+	}
+
+	now := time.Now()
+
+	started, err := time.Parse(time.RFC3339, pod.Annotations[infrav1beta1.DrainStartedAnnotationName])
+	if pod.Labels[infrav1beta1.ServingLabelName] == "false" && err == nil {
+		if remaining := started.Add(APIServerDrainDelay).Sub(now); remaining > 0 {
+			return remaining, nil
+		}
+		return 0, nil
+	}
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	pod.Labels[infrav1beta1.ServingLabelName] = "false"
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[infrav1beta1.DrainStartedAnnotationName] = now.UTC().Format(time.RFC3339)
+	if err := c.Patch(ctx, pod, patch); err != nil {
+		return 0, err
+	}
+
+	return APIServerDrainDelay, nil
+}
+
+// restoreAPIServerPod puts the drained API Server pod back into the endpoints of the API Server
+// Service.
+func restoreAPIServerPod(ctx context.Context, c client.Client, pod *v1.Pod) error {
+	if pod.Labels[infrav1beta1.ServingLabelName] != "false" {
+		return nil
+	}
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	pod.Labels[infrav1beta1.ServingLabelName] = "true"
+	delete(pod.Annotations, infrav1beta1.DrainStartedAnnotationName)
+
+	return c.Patch(ctx, pod, patch)
+}
@@ -18,12 +18,21 @@ package infrastructure
 
 import (
 	"context"
+	"fmt"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
 )
@@ -37,25 +46,59 @@ type KinkClusterReconciler struct {
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=kinkclusters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=kinkclusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=kinkclusters/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-func (r *KinkClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *KinkClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reterr error) {
 	logger := log.FromContext(ctx)
 
-	cluster := &infrav1beta1.KinkCluster{}
-	if err := r.Client.Get(ctx, req.NamespacedName, cluster); err != nil {
+	kinkCluster := &infrav1beta1.KinkCluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, kinkCluster); err != nil {
 		if apierrors.IsNotFound(err) {
 			return ctrl.Result{}, nil
 		}
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, err
 	}
 
-	cluster.Status.Ready = true
+	patchHelper, err := patch.NewHelper(kinkCluster, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	defer func() {
+		conditions.SetSummary(kinkCluster, conditions.WithConditions(infrav1beta1.EndpointReadyCondition))
+
+		if err := patchHelper.Patch(ctx, kinkCluster,
+			patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
+				clusterv1.ReadyCondition,
+				infrav1beta1.EndpointReadyCondition,
+			}},
+		); err != nil {
+			logger.Error(err, "Failed to patch KinkCluster", "KinkCluster", kinkCluster)
+			reterr = kerrors.NewAggregate([]error{reterr, err})
+		}
+	}()
+
+	// There is nothing to provision for the cluster, the control plane runs as pods.
+	kinkCluster.Status.Ready = true
+
+	endpoint := kinkCluster.Spec.ControlPlaneEndpoint
+	if !endpoint.IsValid() {
+		cluster, err := util.GetOwnerCluster(ctx, r.Client, kinkCluster.ObjectMeta)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if cluster != nil {
+			endpoint = cluster.Spec.ControlPlaneEndpoint
+		}
+	}
 
-	if err := r.Status().Update(ctx, cluster); err != nil {
-		logger.Error(err, "Failed to update KinkCluster status.", "KinkCluster", cluster)
-		return ctrl.Result{Requeue: true}, err
+	if endpoint.IsValid() {
+		conditions.MarkTrue(kinkCluster, infrav1beta1.EndpointReadyCondition)
+	} else {
+		conditions.MarkFalse(kinkCluster, infrav1beta1.EndpointReadyCondition, infrav1beta1.WaitingForControlPlaneEndpointReason,
+			clusterv1.ConditionSeverityInfo, "Waiting for the control plane endpoint of the cluster")
 	}
 
 	return ctrl.Result{}, nil
@@ -65,5 +108,34 @@ func (r *KinkClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 func (r *KinkClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrav1beta1.KinkCluster{}).
+		Watches(
+			&source.Kind{Type: &clusterv1.Cluster{}},
+			handler.EnqueueRequestsFromMapFunc(r.ClusterToKinkCluster)).
 		Complete(r)
 }
+
+// ClusterToKinkCluster maps the Cluster to its KinkCluster, so the endpoint of the Cluster is
+// reported once it is set.
+func (r *KinkClusterReconciler) ClusterToKinkCluster(o client.Object) []reconcile.Request {
+	c, ok := o.(*clusterv1.Cluster)
+	if !ok {
+		panic(fmt.Sprintf("Expected a Cluster but got a %T", o))
+	}
+
+	infraRef := c.Spec.InfrastructureRef
+	if infraRef != nil && infraRef.Kind == "KinkCluster" {
+		namespace := infraRef.Namespace
+		if len(namespace) == 0 {
+			namespace = c.Namespace
+		}
+		return []ctrl.Request{
+			{
+				NamespacedName: client.ObjectKey{
+					Namespace: namespace,
+					Name:      infraRef.Name,
+				},
+			},
+		}
+	}
+	return nil
+}
@@ -18,18 +18,31 @@ package infrastructure
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
 	"openbce.io/kink/controllers/infrastructure/templates"
 )
@@ -44,11 +57,10 @@ type KinkMachineReconciler struct {
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=kinkmachines/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=kinkmachines/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-func (r *KinkMachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *KinkMachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reterr error) {
 	logger := log.FromContext(ctx)
 
 	machine := &infrav1beta1.KinkMachine{}
@@ -56,30 +68,61 @@ func (r *KinkMachineReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if apierrors.IsNotFound(err) {
 			return ctrl.Result{}, nil
 		}
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, err
+	}
+
+	patchHelper, err := patch.NewHelper(machine, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
+	defer func() {
+		summary := make([]clusterv1.ConditionType, 0, len(infrav1beta1.ControlPlaneRoles))
+		for _, role := range infrav1beta1.ControlPlaneRoles {
+			summary = append(summary, roleConditions[role])
+		}
+		conditions.SetSummary(machine, conditions.WithConditions(summary...))
+
+		if err := patchHelper.Patch(ctx, machine,
+			patch.WithOwnedConditions{Conditions: append(summary, clusterv1.ReadyCondition, infrav1beta1.PodsUpToDateCondition,
+				infrav1beta1.EtcdUpToDateCondition)},
+		); err != nil {
+			logger.Error(err, "Failed to patch KinkMachine", "KinkMachine", machine)
+			reterr = kerrors.NewAggregate([]error{reterr, err})
+		}
+	}()
+
 	cluster := &clusterv1.Cluster{}
 	clusterName := types.NamespacedName{
 		Namespace: machine.Namespace,
 		Name:      machine.Labels[clusterv1.ClusterLabelName],
 	}
 	if err := r.Get(ctx, clusterName, cluster); err != nil {
-		logger.Error(err, "Failed to get cluster for KinkMachine", "KinkMachine", machine)
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, errors.Wrap(err, "failed to get cluster for KinkMachine")
+	}
+
+	kcp, err := r.getOwnerControlPlane(ctx, machine)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to get KinkControlPlane for KinkMachine")
+	}
+
+	// Keep the pods as they are until the invalid customization of the components is fixed,
+	// which is reported by KinkControlPlane.
+	if err := templates.ValidateComponents(kcp); err != nil {
+		logger.Info("Skip the invalid components of KinkControlPlane", "KinkMachine", machine, "reason", err.Error())
+		return ctrl.Result{}, nil
 	}
 
-	if err := r.lookupOrSetupControlPlane(ctx, cluster, machine); err != nil {
-		logger.Error(err, "Failed to setup pods for KinkMachine", "KinkMachine", machine)
-		return ctrl.Result{Requeue: true}, nil
+	requeueAfter, err := r.lookupOrSetupControlPlane(ctx, cluster, kcp, machine)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to setup pods for KinkMachine")
 	}
 
 	if err := r.updateMachineStatus(ctx, cluster, machine); err != nil {
-		logger.Error(err, "Failed to update the status of KinkMachine", "KinkMachine", machine)
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{}, errors.Wrap(err, "failed to update the status of KinkMachine")
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -87,10 +130,67 @@ func (r *KinkMachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrav1beta1.KinkMachine{}).
 		Owns(&v1.Pod{}).
+		Watches(
+			&source.Kind{Type: &ctrlv1beta1.KinkControlPlane{}},
+			handler.EnqueueRequestsFromMapFunc(r.KinkCtrlPlaneToMachines)).
 		Complete(r)
 }
 
-func (r *KinkMachineReconciler) lookupOrSetupPods(ctx context.Context, cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) error {
+func (r *KinkMachineReconciler) KinkCtrlPlaneToMachines(o client.Object) []reconcile.Request {
+	kcp, ok := o.(*ctrlv1beta1.KinkControlPlane)
+	if !ok {
+		panic(fmt.Sprintf("Expected a KinkControlPlane but got a %T", o))
+	}
+
+	kms := &infrav1beta1.KinkMachineList{}
+	if err := r.List(context.Background(), kms,
+		client.InNamespace(kcp.Namespace),
+		client.MatchingLabels{
+			clusterv1.ClusterLabelName: kcp.Spec.ClusterName,
+		},
+	); err != nil {
+		return nil
+	}
+
+	var res []reconcile.Request
+	for _, m := range kms.Items {
+		if !metav1.IsControlledBy(&m, kcp) {
+			continue
+		}
+		res = append(res, reconcile.Request{
+			NamespacedName: client.ObjectKeyFromObject(&m),
+		})
+	}
+
+	return res
+}
+
+// getOwnerControlPlane returns the KinkControlPlane that controls the KinkMachine.
+func (r *KinkMachineReconciler) getOwnerControlPlane(ctx context.Context, machine *infrav1beta1.KinkMachine) (*ctrlv1beta1.KinkControlPlane, error) {
+	owner := metav1.GetControllerOf(machine)
+	if owner == nil || owner.Kind != "KinkControlPlane" {
+		return nil, fmt.Errorf("KinkMachine %s/%s is not controlled by a KinkControlPlane", machine.Namespace, machine.Name)
+	}
+
+	kcp := &ctrlv1beta1.KinkControlPlane{}
+	kcpName := types.NamespacedName{
+		Namespace: machine.Namespace,
+		Name:      owner.Name,
+	}
+	if err := r.Get(ctx, kcpName, kcp); err != nil {
+		return nil, err
+	}
+
+	return kcp, nil
+}
+
+// lookupOrSetupPods creates the pods of each role, and rolls the outdated ones if KinkControlPlane
+// allows the KinkMachine to: the roles are rolled one at a time, in the order of their
+// dependencies, each once the pods before it are up to date and ready. The etcd pod is never
+// rolled, as its data would be lost; an outdated one is reported by the EtcdUpToDate condition
+// instead. It returns when to check the API Server pod being drained again.
+func (r *KinkMachineReconciler) lookupOrSetupPods(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) (time.Duration, error) {
 	logger := log.FromContext(ctx)
 
 	podList := &v1.PodList{}
@@ -99,30 +199,114 @@ func (r *KinkMachineReconciler) lookupOrSetupPods(ctx context.Context, cluster *
 		client.MatchingLabels{
 			clusterv1.ClusterLabelName: cluster.Name,
 		}); err != nil {
-		return err
+		return 0, err
 	}
 
 	machine.Status.Pods = nil
 
+	var requeueAfter time.Duration
+
 	podMap := map[infrav1beta1.ControlPlaneRole]*v1.Pod{}
 
-	for _, pod := range podList.Items {
-		if !util.IsOwnedByObject(&pod, machine) {
-			continue
-		}
+	// The roles with a ready pod in the cluster, the pods of the dependent roles reach them
+	// through the Services.
+	readyRoles := map[infrav1beta1.ControlPlaneRole]bool{}
+
+	for i := range podList.Items {
+		pod := &podList.Items[i]
 
 		podType, err := getControlPlaneRole(&pod.ObjectMeta)
 		if err != nil {
 			continue
 		}
 
-		podMap[podType] = &pod
+		if pod.DeletionTimestamp.IsZero() && isPodContainersReady(pod) {
+			readyRoles[podType] = true
+		}
+
+		if !util.IsOwnedByObject(pod, machine) {
+			continue
+		}
+
+		podMap[podType] = pod
 	}
 
-	podTemplates := r.getControlPlanePodTemplates(cluster, machine)
+	podTemplates := r.getControlPlanePodTemplates(cluster, kcp, machine)
+
+	_, rolloutAllowed := machine.Annotations[infrav1beta1.RolloutAllowedAnnotationName]
+	_, draining := machine.Annotations[infrav1beta1.DrainStartedAnnotationName]
+	rollable := rolloutAllowed && !draining
+
+	var outdated []string
+	rolling, etcdOutdated := false, false
+
+	for _, t := range infrav1beta1.ControlPlaneRoles {
+		pt := podTemplates[t]
+		condition := roleConditions[t]
+
+		hash := templates.ComputeHash(pt)
+		if pt.Annotations == nil {
+			pt.Annotations = map[string]string{}
+		}
+		pt.Annotations[infrav1beta1.TemplateHashAnnotationName] = hash
+
+		pending := getPendingDependencies(t, readyRoles)
 
-	for t, pt := range podTemplates {
 		if pod, found := podMap[t]; found {
+			// Wait for the outdated pod to go away before creating the new one.
+			if !pod.DeletionTimestamp.IsZero() {
+				conditions.MarkFalse(machine, condition, infrav1beta1.PodRollingReason, clusterv1.ConditionSeverityInfo,
+					"Waiting for the outdated pod %s to terminate", pod.Name)
+				if t != infrav1beta1.ETCD {
+					outdated = append(outdated, string(t))
+					rolling = true
+				}
+				rollable = false
+				continue
+			}
+
+			// The template was changed, roll the pod once its dependencies are ready.
+			podOutdated := pod.Annotations[infrav1beta1.TemplateHashAnnotationName] != hash
+			if podOutdated && t == infrav1beta1.ETCD {
+				etcdOutdated, podOutdated = true, false
+			}
+			if podOutdated {
+				outdated = append(outdated, string(t))
+			}
+			if podOutdated && rollable && len(pending) == 0 {
+				rolling, rollable = true, false
+
+				// Take the API Server out of the endpoints before deleting it.
+				wait, err := DrainAPIServerPod(ctx, r.Client, pod)
+				if err != nil {
+					return 0, err
+				}
+				if wait > 0 {
+					conditions.MarkFalse(machine, condition, infrav1beta1.PodRollingReason, clusterv1.ConditionSeverityInfo,
+						"Draining the outdated pod %s", pod.Name)
+					if requeueAfter == 0 || wait < requeueAfter {
+						requeueAfter = wait
+					}
+					continue
+				}
+
+				logger.Info("Rolling outdated pod of machine", "pod", pod.Name, "role", t)
+				if err := r.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+					return 0, err
+				}
+				conditions.MarkFalse(machine, condition, infrav1beta1.PodRollingReason, clusterv1.ConditionSeverityInfo,
+					"Rolling the outdated pod %s", pod.Name)
+				continue
+			}
+
+			// The pod was drained for a rollout that was reverted or is not allowed any more, serve
+			// it again; unless the machine is being removed.
+			if !draining {
+				if err := restoreAPIServerPod(ctx, r.Client, pod); err != nil {
+					return 0, err
+				}
+			}
+
 			podRef := v1.ObjectReference{
 				Name:       pod.Name,
 				Namespace:  pod.Namespace,
@@ -132,75 +316,61 @@ func (r *KinkMachineReconciler) lookupOrSetupPods(ctx context.Context, cluster *
 			}
 
 			machine.Status.Pods = append(machine.Status.Pods, podRef)
-			continue
-		}
 
-		if err := r.Create(ctx, pt); err != nil {
-			logger.Error(err, "Failed to create pod for machine", "pod", pt)
-			return err
+			if isPodContainersReady(pod) {
+				conditions.MarkTrue(machine, condition)
+			} else {
+				conditions.MarkFalse(machine, condition, infrav1beta1.PodNotReadyReason, clusterv1.ConditionSeverityWarning,
+					"Pod %s is not ready", pod.Name)
+				rollable = false
+			}
+			continue
 		}
-	}
 
-	return nil
-}
+		rollable = false
 
-func (r *KinkMachineReconciler) lookupOrSetupServices(ctx context.Context, cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) error {
-	svcList := &v1.ServiceList{}
-	if err := r.List(ctx, svcList,
-		client.InNamespace(cluster.Namespace),
-		client.MatchingLabels{
-			clusterv1.ClusterLabelName: cluster.Name,
-		}); err != nil {
-		return err
-	}
-
-	machine.Status.Pods = nil
-
-	svcMap := map[infrav1beta1.ControlPlaneRole]*v1.Service{}
-
-	for _, svc := range svcList.Items {
-		if !util.IsOwnedByObject(&svc, machine) {
+		if len(pending) > 0 {
+			conditions.MarkFalse(machine, condition, infrav1beta1.WaitingForDependenciesReason, clusterv1.ConditionSeverityInfo,
+				"Waiting for %s to be ready", strings.Join(pending, ", "))
 			continue
 		}
 
-		svcRole, err := getControlPlaneRole(&svc.ObjectMeta)
-		if err != nil {
-			continue
+		if err := r.Create(ctx, pt); err != nil {
+			logger.Error(err, "Failed to create pod for machine", "pod", pt)
+			return 0, err
 		}
-
-		svcMap[svcRole] = &svc
+		conditions.MarkFalse(machine, condition, infrav1beta1.PodCreatingReason, clusterv1.ConditionSeverityInfo,
+			"Creating pod %s", pt.Name)
 	}
 
-	svcTemplates := r.getControlPlaneServiceTemplates(cluster, machine)
-
-	for t, st := range svcTemplates {
-		if _, found := svcMap[t]; found {
-			continue
-		}
+	switch {
+	case rolling:
+		conditions.MarkFalse(machine, infrav1beta1.PodsUpToDateCondition, infrav1beta1.PodRollingReason,
+			clusterv1.ConditionSeverityInfo, "Rolling the outdated pods of %s", strings.Join(outdated, ", "))
+	case len(outdated) > 0:
+		conditions.MarkFalse(machine, infrav1beta1.PodsUpToDateCondition, infrav1beta1.PodsOutdatedReason,
+			clusterv1.ConditionSeverityInfo, "The pods of %s are outdated", strings.Join(outdated, ", "))
+	default:
+		conditions.MarkTrue(machine, infrav1beta1.PodsUpToDateCondition)
+	}
 
-		if err := r.Create(ctx, st); err != nil {
-			continue
-		}
+	if etcdOutdated {
+		conditions.MarkFalse(machine, infrav1beta1.EtcdUpToDateCondition, infrav1beta1.EtcdOutdatedReason,
+			clusterv1.ConditionSeverityWarning, "The etcd pod is outdated, replace the KinkMachine to update it")
+	} else {
+		conditions.MarkTrue(machine, infrav1beta1.EtcdUpToDateCondition)
 	}
 
-	return nil
+	return requeueAfter, nil
 }
 
-func (r *KinkMachineReconciler) getControlPlanePodTemplates(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) map[infrav1beta1.ControlPlaneRole]*v1.Pod {
+func (r *KinkMachineReconciler) getControlPlanePodTemplates(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) map[infrav1beta1.ControlPlaneRole]*v1.Pod {
 	res := map[infrav1beta1.ControlPlaneRole]*v1.Pod{}
 
-	res[infrav1beta1.ETCD] = templates.EtcdPodTemplate(cluster, machine)
-	res[infrav1beta1.ApiServer] = templates.ApiServerPodTemplate(cluster, machine)
-	res[infrav1beta1.ControllerManager] = templates.ControllerManagerPodTemplate(cluster, machine)
-	res[infrav1beta1.Scheduler] = templates.SchedulerPodTemplate(cluster, machine)
-
-	return res
-}
-
-func (r *KinkMachineReconciler) getControlPlaneServiceTemplates(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) map[infrav1beta1.ControlPlaneRole]*v1.Service {
-	res := map[infrav1beta1.ControlPlaneRole]*v1.Service{}
-
-	res[infrav1beta1.ETCD] = templates.EtcdServiceTemplate(cluster, machine)
+	res[infrav1beta1.ETCD] = templates.EtcdPodTemplate(cluster, kcp, machine)
+	res[infrav1beta1.ApiServer] = templates.ApiServerPodTemplate(cluster, kcp, machine)
+	res[infrav1beta1.ControllerManager] = templates.ControllerManagerPodTemplate(cluster, kcp, machine)
+	res[infrav1beta1.Scheduler] = templates.SchedulerPodTemplate(cluster, kcp, machine)
 
 	return res
 }
@@ -215,36 +385,38 @@ func (r *KinkMachineReconciler) updateMachineStatus(ctx context.Context, cluster
 		return err
 	}
 
-	machine.Status.Ready = true
 	machine.Status.FailureMessage = nil
 	machine.Status.FailureReason = nil
 
-	for _, pod := range podList.Items {
-		if !util.IsOwnedByObject(&pod, machine) {
+	// The machine is ready when the pod of every role is ready, i.e. all its containers pass
+	// their readiness probes.
+	readyRoles := map[infrav1beta1.ControlPlaneRole]bool{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !util.IsOwnedByObject(pod, machine) || !pod.DeletionTimestamp.IsZero() {
 			continue
 		}
 
-		if pod.Status.Phase != v1.PodRunning {
-			machine.Status.Ready = false
-			break
+		role, err := getControlPlaneRole(&pod.ObjectMeta)
+		if err != nil {
+			continue
 		}
+
+		readyRoles[role] = isPodContainersReady(pod)
 	}
 
-	if err := r.Status().Update(ctx, machine); err != nil {
-		return err
+	machine.Status.Ready = true
+	for _, role := range infrav1beta1.ControlPlaneRoles {
+		if !readyRoles[role] {
+			machine.Status.Ready = false
+			break
+		}
 	}
 
 	return nil
 }
 
-func (r *KinkMachineReconciler) lookupOrSetupControlPlane(ctx context.Context, cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) error {
-	if err := r.lookupOrSetupPods(ctx, cluster, machine); err != nil {
-		return err
-	}
-
-	if err := r.lookupOrSetupServices(ctx, cluster, machine); err != nil {
-		return err
-	}
-
-	return nil
+func (r *KinkMachineReconciler) lookupOrSetupControlPlane(ctx context.Context, cluster *clusterv1.Cluster,
+	kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) (time.Duration, error) {
+	return r.lookupOrSetupPods(ctx, cluster, kcp, machine)
 }
@@ -19,82 +19,227 @@ package templates
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apiserver/pkg/storage/names"
+	"k8s.io/utils/pointer"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
 )
 
-func ApiServerPodTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) *v1.Pod {
+// certSANsAnnotationName records the extra SANs of the serving certificate, so the API Server
+// pods are rolled to pick up the re-issued certificate when they are changed.
+const certSANsAnnotationName = "kink.openbce.io/cert-sans"
+
+const ApiServerDefaultPort = 6443
+
+const (
+	// apiServerShutdownDelay keeps the API Server serving after it is asked to stop, while it
+	// reports not ready, so the in-flight and new requests are not dropped.
+	apiServerShutdownDelay = 10 * time.Second
+
+	// apiServerPreStopDelay waits for the endpoints of the pod to be removed before stopping.
+	apiServerPreStopDelay = 5 * time.Second
+
+	// apiServerTerminationGracePeriod covers the delays above and the longest requests.
+	apiServerTerminationGracePeriod int64 = 60
+)
+
+// ApiServerServiceTemplate exposes the API Server pods, which are not reachable by the address
+// of the hosts when they run on the pod network.
+func ApiServerServiceTemplate(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) *v1.Service {
+	owner := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
+
+	serviceType := v1.ServiceTypeClusterIP
+	if len(kcp.Spec.APIServerServiceType) > 0 {
+		serviceType = kcp.Spec.APIServerServiceType
+	}
+
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secrets.APIServerServiceName(cluster),
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				clusterv1.ClusterLabelName:             cluster.Name,
+				infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+			},
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Port:       ApiServerDefaultPort,
+					TargetPort: intstr.FromInt(ApiServerDefaultPort),
+				},
+			},
+			Selector: map[string]string{
+				clusterv1.ClusterLabelName:             cluster.Name,
+				infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+				infrav1beta1.ServingLabelName:          "true",
+			},
+			Type: serviceType,
+		},
+	}
+}
+
+func ApiServerPodTemplate(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) *v1.Pod {
 	owner := metav1.NewControllerRef(machine,
 		infrav1beta1.GroupVersion.WithKind("KinkMachine"))
 
-	volumes, mounts := getSecretVolumes(cluster)
+	volumes, mounts := getSecretVolumes(cluster, infrav1beta1.ApiServer)
 
 	serviceDIDR := "192.168.0.0/24"
 	if len(cluster.Spec.ClusterNetwork.Services.CIDRBlocks) != 0 {
 		serviceDIDR = cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0]
 	}
 
-	return &v1.Pod{
+	args := []string{
+		"kube-apiserver",
+		"--advertise-address=${host_ip}",
+		fmt.Sprintf("--secure-port=%d", ApiServerDefaultPort),
+		fmt.Sprintf("--shutdown-delay-duration=%s", apiServerShutdownDelay),
+		fmt.Sprintf("--etcd-servers=http://%s-etcd-svc.%s:%d",
+			cluster.Name, cluster.Namespace, EtcdDefaultPort),
+		fmt.Sprintf("--service-cluster-ip-range=%s", serviceDIDR),
+
+		"--allow-privileged=true",
+		fmt.Sprintf("--enable-admission-plugins=%s", strings.Join(getAdmissionPlugins(kcp, machine), ",")),
+		"--enable-aggregator-routing=true",
+		"--enable-bootstrap-token-auth=true",
+		"--kubelet-preferred-address-types=InternalIP,ExternalIP,Hostname",
+		"--requestheader-allowed-names=front-proxy-client",
+		"--requestheader-extra-headers-prefix=X-Remote-Extra-",
+		"--requestheader-group-headers=X-Remote-Group",
+		"--requestheader-username-headers=X-Remote-User",
+
+		"--client-ca-file=/etc/kubernetes/pki/ca/tls.crt",
+		"--requestheader-client-ca-file=/etc/kubernetes/pki/front-proxy-ca/tls.crt",
+		"--proxy-client-cert-file=/etc/kubernetes/pki/front-proxy-client/tls.crt",
+		"--proxy-client-key-file=/etc/kubernetes/pki/front-proxy-client/tls.key",
+		"--kubelet-client-certificate=/etc/kubernetes/pki/kubelet-client/tls.crt",
+		"--kubelet-client-key=/etc/kubernetes/pki/kubelet-client/tls.key",
+		"--service-account-key-file=/etc/kubernetes/pki/sa/" + secrets.SAPublicKeysDataName,
+		"--service-account-signing-key-file=/etc/kubernetes/pki/sa/tls.key",
+		"--tls-cert-file=/etc/kubernetes/pki/apiserver/tls.crt",
+		"--tls-private-key-file=/etc/kubernetes/pki/apiserver/tls.key",
+	}
+	args = append(args, getServiceAccountArgs(cluster, kcp)...)
+	args = append(args, getSecurityProfileArgs(kcp)...)
+
+	profileArgs, profileVolumes, profileMounts := getAPIServerProfileArgs(cluster, kcp, machine)
+	args = append(args, profileArgs...)
+	volumes = append(volumes, profileVolumes...)
+	mounts = append(mounts, profileMounts...)
+
+	authnArgs, authnVolumes, authnMounts := getAuthenticationArgs(kcp)
+	args = append(args, authnArgs...)
+	volumes = append(volumes, authnVolumes...)
+	mounts = append(mounts, authnMounts...)
+
+	authzArgs, authzVolumes, authzMounts := getAuthorizationArgs(kcp)
+	args = append(args, authzArgs...)
+	volumes = append(volumes, authzVolumes...)
+	mounts = append(mounts, authzMounts...)
+
+	encryptionArgs, encryptionVolumes, encryptionMounts, encryptionContainers := getEncryptionArgs(cluster, kcp, machine)
+	args = append(args, encryptionArgs...)
+	volumes = append(volumes, encryptionVolumes...)
+	mounts = append(mounts, encryptionMounts...)
+
+	auditArgs, auditVolumes, auditMounts, auditContainers := getAuditArgs(cluster, kcp)
+	args = append(args, auditArgs...)
+	volumes = append(volumes, auditVolumes...)
+	mounts = append(mounts, auditMounts...)
+
+	args = getComponentArgs(kcp, infrav1beta1.ApiServer, args)
+
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      names.SimpleNameGenerator.GenerateName(cluster.Name + "-apiserver-"),
 			Namespace: cluster.Namespace,
 			Labels: map[string]string{
 				clusterv1.ClusterLabelName:             cluster.Name,
 				infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+				infrav1beta1.ServingLabelName:          "true",
+			},
+			Annotations: map[string]string{
+				certSANsAnnotationName:               strings.Join(kcp.Spec.CertSANs, ","),
+				secrets.SAKeyIDsAnnotationName:       getSAKeyIDs(kcp),
+				secrets.SASigningKeyIDAnnotationName: getSASigningKeyID(kcp),
+				auditPolicyAnnotationName:            getAuditPolicyHash(kcp),
+				EncryptionConfigAnnotationName:       EncryptionConfigHash(kcp.Status.Encryption),
 			},
 			OwnerReferences: []metav1.OwnerReference{*owner},
 		},
 		Spec: v1.PodSpec{
-			RestartPolicy: v1.RestartPolicyAlways,
-			HostNetwork:   true,
-			DNSPolicy:     v1.DNSClusterFirstWithHostNet,
-			Containers: []v1.Container{
+			RestartPolicy:                 v1.RestartPolicyAlways,
+			TerminationGracePeriodSeconds: pointer.Int64(apiServerTerminationGracePeriod),
+			Containers: append([]v1.Container{
 				{
-					Name:    "apiserver",
-					Image:   "openbce/kube-apiserver:v1.24.1",
-					Env:     []v1.EnvVar{hostIPEnvVar},
-					Command: []string{"/bin/sh", "-c"},
-					Args: []string{strings.Join([]string{
-						"kube-apiserver",
-						"--advertise-address=${host_ip}",
-						"--secure-port=6443",
-						fmt.Sprintf("--etcd-servers=http://%s-etcd-svc.%s:%d",
-							cluster.Name, cluster.Namespace, EtcdDefaultPort),
-						fmt.Sprintf("--service-cluster-ip-range=%s", serviceDIDR),
-
-						"--allow-privileged=true",
-						"--authorization-mode=Node,RBAC",
-						"--enable-admission-plugins=NodeRestriction",
-						"--enable-bootstrap-token-auth=true",
-						"--kubelet-preferred-address-types=InternalIP,ExternalIP,Hostname",
-						"--requestheader-allowed-names=front-proxy-client",
-						"--requestheader-extra-headers-prefix=X-Remote-Extra-",
-						"--requestheader-group-headers=X-Remote-Group",
-						"--requestheader-username-headers=X-Remote-User",
-
-						"--client-ca-file=/etc/kubernetes/pki/ca/tls.crt",
-						"--requestheader-client-ca-file=/etc/kubernetes/pki/ca/tls.crt",
-						"--proxy-client-cert-file=/etc/kubernetes/pki/front-proxy-ca/tls.crt",
-						"--proxy-client-key-file=/etc/kubernetes/pki/front-proxy-ca/tls.key",
-						"--kubelet-client-certificate=/etc/kubernetes/pki/kubelet-client/tls.crt",
-						"--kubelet-client-key=/etc/kubernetes/pki/kubelet-client/tls.key",
-						"--service-account-issuer=https://kubernetes.default.svc.cluster.local",
-						"--service-account-key-file=/etc/kubernetes/pki/sa/tls.crt",
-						"--service-account-signing-key-file=/etc/kubernetes/pki/sa/tls.key",
-						"--tls-cert-file=/etc/kubernetes/pki/apiserver/tls.crt",
-						"--tls-private-key-file=/etc/kubernetes/pki/apiserver/tls.key"},
-						" "),
-					},
+					Name:         "apiserver",
+					Image:        getComponentImage("kube-apiserver", machine),
+					Env:          []v1.EnvVar{hostIPEnvVar},
+					Command:      []string{"/bin/sh", "-c"},
+					Args:         []string{strings.Join(args, " ")},
 					VolumeMounts: mounts,
+					Lifecycle: &v1.Lifecycle{
+						PreStop: &v1.LifecycleHandler{
+							Exec: &v1.ExecAction{
+								Command: []string{"/bin/sh", "-c", fmt.Sprintf("sleep %d", int(apiServerPreStopDelay.Seconds()))},
+							},
+						},
+					},
 				},
-			},
+			}, append(encryptionContainers, auditContainers...)...),
 			Volumes: volumes,
 		},
 	}
+
+	setScheduling(pod, cluster, kcp, infrav1beta1.ApiServer)
+	setResources(pod, kcp, infrav1beta1.ApiServer)
+	setProbes(pod, kcp, infrav1beta1.ApiServer)
+	setComponent(pod, kcp, infrav1beta1.ApiServer)
+	setPodSecurity(pod, kcp)
+
+	return pod
+}
+
+// getServiceAccountArgs sets the issuers and the JWKS URI of the issuer, and limits the lifetime of the service
+// account tokens when the signing key is rotated, so no token outlives the retired key that
+// signed it.
+func getServiceAccountArgs(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) []string {
+	// The first issuer issues the tokens, the previous ones are only accepted.
+	args := []string{fmt.Sprintf("--service-account-issuer=%s", secrets.GetServiceAccountIssuer(cluster, kcp))}
+	for _, i := range kcp.Status.ServiceAccountIssuers {
+		if i.RetiredAt != nil {
+			args = append(args, fmt.Sprintf("--service-account-issuer=%s", i.Issuer))
+		}
+	}
+
+	if jwksURI := secrets.GetServiceAccountJWKSURI(cluster, kcp); len(jwksURI) > 0 {
+		args = append(args, fmt.Sprintf("--service-account-jwks-uri=%s", jwksURI))
+	}
+
+	sa := kcp.Spec.ServiceAccount
+	if sa == nil || (sa.KeyRotationPeriod == nil && sa.MaxTokenExpiration == nil) {
+		return args
+	}
+
+	maxTokenExpiration := secrets.DefaultMaxTokenExpiration
+	if sa.MaxTokenExpiration != nil {
+		maxTokenExpiration = sa.MaxTokenExpiration.Duration
+	}
+
+	return append(args,
+		fmt.Sprintf("--service-account-max-token-expiration=%s", maxTokenExpiration),
+		"--service-account-extend-token-expiration=false",
+	)
 }
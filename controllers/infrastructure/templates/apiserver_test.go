@@ -0,0 +1,410 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+const (
+	fakeGroup   = "fake.kink.openbce.io"
+	fakeVersion = "v1"
+)
+
+// frontProxyFlags are the flags of the API Server to proxy the requests to the aggregated API
+// servers, and of the aggregated API servers to authenticate the proxied requests.
+var frontProxyFlags = []string{
+	"requestheader-client-ca-file",
+	"requestheader-allowed-names",
+	"requestheader-extra-headers-prefix",
+	"requestheader-group-headers",
+	"requestheader-username-headers",
+	"proxy-client-cert-file",
+	"proxy-client-key-file",
+}
+
+// pathFlags are the flags of files, which are mounted from the Secrets of the cluster.
+var pathFlags = map[string]bool{
+	"requestheader-client-ca-file": true,
+	"proxy-client-cert-file":       true,
+	"proxy-client-key-file":        true,
+}
+
+// proxiedRequest is a request proxied by the API Server to the fake aggregated API server.
+type proxiedRequest struct {
+	path       string
+	clientName string
+	user       string
+	groups     []string
+}
+
+// fakeAggregatedServer serves the discovery of an API group, and records the requests proxied
+// by the API Server, which are authenticated as the requestheader authenticator of an
+// aggregated API server does.
+type fakeAggregatedServer struct {
+	allowedNames map[string]bool
+	userHeader   string
+	groupHeader  string
+
+	lock     sync.Mutex
+	requests []proxiedRequest
+}
+
+func (s *fakeAggregatedServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// The client certificate is verified against the front proxy CA by the TLS config.
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		http.Error(rw, "no client certificate", http.StatusUnauthorized)
+		return
+	}
+	clientName := req.TLS.PeerCertificates[0].Subject.CommonName
+	if !s.allowedNames[clientName] {
+		http.Error(rw, "client certificate is not allowed: "+clientName, http.StatusUnauthorized)
+		return
+	}
+
+	s.lock.Lock()
+	s.requests = append(s.requests, proxiedRequest{
+		path:       req.URL.Path,
+		clientName: clientName,
+		user:       req.Header.Get(s.userHeader),
+		groups:     req.Header.Values(s.groupHeader),
+	})
+	s.lock.Unlock()
+
+	if req.URL.Path != "/apis/"+fakeGroup+"/"+fakeVersion {
+		http.NotFound(rw, req)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(&metav1.APIResourceList{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "APIResourceList",
+		},
+		GroupVersion: fakeGroup + "/" + fakeVersion,
+		APIResources: []metav1.APIResource{},
+	})
+}
+
+// userRequests returns the requests proxied for the users, the probes of the availability of
+// the APIService have no user.
+func (s *fakeAggregatedServer) userRequests() []proxiedRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var res []proxiedRequest
+	for _, r := range s.requests {
+		if len(r.user) > 0 {
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
+
+// apiServerFlags returns the flags of the API Server pod of KinkControlPlane, the paths of the
+// files are moved under root, where the Secrets mounted by the pod are written.
+func apiServerFlags(t *testing.T, root string) map[string]string {
+	ctx := context.Background()
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: clusterv1.ClusterSpec{
+			ClusterNetwork: &clusterv1.ClusterNetwork{
+				Services: &clusterv1.NetworkRanges{CIDRBlocks: []string{"10.0.0.0/24"}},
+			},
+		},
+	}
+	kcp := &ctrlv1beta1.KinkControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+	}
+	machine := &infrav1beta1.KinkMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default"},
+	}
+
+	// Generate the front proxy certificates as KinkControlPlane does.
+	c := fake.NewClientBuilder().Build()
+	tree, err := secrets.Certificates{
+		secrets.KinkCertFrontProxyCA(),
+		secrets.KinkCertFrontProxyClient(),
+	}.AsMap().CertTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CreateTree(ctx, c, kcp, cluster); err != nil {
+		t.Fatalf("failed to create the front proxy certificates: %v", err)
+	}
+
+	pod := templates.ApiServerPodTemplate(cluster, kcp, machine)
+	container := pod.Spec.Containers[0]
+
+	volumes := map[string]v1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+
+	for _, m := range container.VolumeMounts {
+		source := volumes[m.Name].Secret
+		if source == nil {
+			continue
+		}
+
+		sec := &v1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: source.SecretName}, sec); err != nil {
+			continue
+		}
+
+		dir := filepath.Join(root, m.MountPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		items := source.Items
+		if len(items) == 0 {
+			for key := range sec.Data {
+				items = append(items, v1.KeyToPath{Key: key, Path: key})
+			}
+		}
+		for _, item := range items {
+			if err := os.WriteFile(filepath.Join(dir, item.Path), sec.Data[item.Key], 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	flags := map[string]string{}
+	for _, arg := range strings.Fields(strings.Join(container.Args, " ")) {
+		if kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2); len(kv) == 2 && strings.HasPrefix(arg, "--") {
+			flags[kv[0]] = kv[1]
+			if pathFlags[kv[0]] {
+				flags[kv[0]] = filepath.Join(root, kv[1])
+			}
+		}
+	}
+
+	return flags
+}
+
+// TestAggregatedAPIServer registers an APIService against a fake aggregated API server, and
+// checks that the API Server, configured with the front proxy flags of kink, proxies the
+// requests with the front proxy client certificate that the aggregated API server trusts. The
+// API Server needs the envtest binaries in KUBEBUILDER_ASSETS, e.g. by "make test"; the test
+// fails without them if CI is set.
+func TestAggregatedAPIServer(t *testing.T) {
+	root := t.TempDir()
+	flags := apiServerFlags(t, root)
+
+	for _, name := range frontProxyFlags {
+		if _, found := flags[name]; !found {
+			t.Fatalf("the flag --%s is not set", name)
+		}
+	}
+
+	// The fake aggregated API server trusts the front proxy CA of the cluster for the client
+	// certificates, as the requestheader authenticator does.
+	caData, err := os.ReadFile(flags["requestheader-client-ca-file"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caData) {
+		t.Fatal("no certificate in the front proxy CA")
+	}
+
+	proxyClient, err := tls.LoadX509KeyPair(flags["proxy-client-cert-file"], flags["proxy-client-key-file"])
+	if err != nil {
+		t.Fatalf("failed to load the front proxy client certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(proxyClient.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:     clientCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Fatalf("the front proxy client certificate is not signed by the front proxy CA: %v", err)
+	}
+
+	aggregated := &fakeAggregatedServer{
+		allowedNames: map[string]bool{},
+		userHeader:   flags["requestheader-username-headers"],
+		groupHeader:  flags["requestheader-group-headers"],
+	}
+	for _, name := range strings.Split(flags["requestheader-allowed-names"], ",") {
+		aggregated.allowedNames[name] = true
+	}
+	if !aggregated.allowedNames[leaf.Subject.CommonName] {
+		t.Fatalf("the front proxy client %q is not in the allowed names %q", leaf.Subject.CommonName, flags["requestheader-allowed-names"])
+	}
+
+	// A local run may go without the envtest binaries, CI must register the APIService.
+	if len(os.Getenv("KUBEBUILDER_ASSETS")) == 0 {
+		if len(os.Getenv("CI")) > 0 {
+			t.Fatal("KUBEBUILDER_ASSETS is not set, run the tests by \"make test\"")
+		}
+		t.Skip("KUBEBUILDER_ASSETS is not set, run the tests by \"make test\" to register the APIService")
+	}
+
+	srv := httptest.NewUnstartedServer(aggregated)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &envtest.Environment{}
+	args := env.ControlPlane.GetAPIServer().Configure()
+	for _, name := range frontProxyFlags {
+		args.Set(name, flags[name])
+	}
+	// There is no network to route to the endpoints in envtest, so the APIService is resolved
+	// by an ExternalName Service of the fake aggregated API server.
+	args.Set("enable-aggregator-routing", "false")
+
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("failed to start the API Server: %v", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("failed to stop the API Server: %v", err)
+		}
+	}()
+
+	c, err := client.New(cfg, client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	port, err := strconv.Atoi(srvURL.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "fake-aggregated", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: "localhost",
+		},
+	}
+	if err := c.Create(ctx, svc); err != nil {
+		t.Fatalf("failed to create the Service: %v", err)
+	}
+
+	apiService := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata": map[string]interface{}{
+			"name": fakeVersion + "." + fakeGroup,
+		},
+		"spec": map[string]interface{}{
+			"group":   fakeGroup,
+			"version": fakeVersion,
+			"service": map[string]interface{}{
+				"name":      svc.Name,
+				"namespace": svc.Namespace,
+				"port":      int64(port),
+			},
+			"insecureSkipTLSVerify": true,
+			"groupPriorityMinimum":  int64(1000),
+			"versionPriority":       int64(100),
+		},
+	}}
+	if err := c.Create(ctx, apiService); err != nil {
+		t.Fatalf("failed to create the APIService: %v", err)
+	}
+
+	if err := wait.PollImmediate(time.Second, time.Minute, func() (bool, error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(apiService), apiService); err != nil {
+			return false, err
+		}
+		conds, _, _ := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+		for _, cond := range conds {
+			m, _ := cond.(map[string]interface{})
+			if m["type"] == "Available" && m["status"] == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}); err != nil {
+		t.Fatalf("the APIService is not available: %v, %v", err, apiService.Object["status"])
+	}
+
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := clientset.Discovery().ServerResourcesForGroupVersion(fakeGroup + "/" + fakeVersion)
+	if err != nil {
+		t.Fatalf("failed to discover the aggregated API: %v", err)
+	}
+	if resources.GroupVersion != fakeGroup+"/"+fakeVersion {
+		t.Errorf("expected the resources of %s/%s, got %s", fakeGroup, fakeVersion, resources.GroupVersion)
+	}
+
+	requests := aggregated.userRequests()
+	if len(requests) == 0 {
+		t.Fatal("no request of the user is proxied to the aggregated API server")
+	}
+	for _, r := range requests {
+		if !aggregated.allowedNames[r.clientName] {
+			t.Errorf("the request %s is proxied with the client certificate of %q", r.path, r.clientName)
+		}
+		if !sets.NewString(r.groups...).Has("system:masters") {
+			t.Errorf("the request %s of %q is proxied without the groups of the user: %v", r.path, r.user, r.groups)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/pkg/errors"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+)
+
+const (
+	// AuditPolicyDataName is the key of the audit policy in its ConfigMap.
+	AuditPolicyDataName = "policy.yaml"
+
+	// auditPolicyAnnotationName records the hash of the audit policy, so the API Server pods
+	// are rolled when it is changed.
+	auditPolicyAnnotationName = "kink.openbce.io/audit-policy"
+
+	auditPolicyVolumeName  = "audit-policy"
+	auditPolicyDir         = "/etc/kubernetes/audit"
+	auditLogVolumeName     = "audit-log"
+	auditLogDir            = "/var/log/kubernetes/audit"
+	auditLogFileName       = "audit.log"
+	auditWebhookVolumeName = "audit-webhook"
+	auditWebhookDir        = "/etc/kubernetes/webhooks/audit"
+
+	defaultAuditStdoutImage = "busybox:1.35"
+
+	// The audit log file on the emptyDir for Stdout is kept small, the sidecar container ships
+	// the events as soon as they are written.
+	defaultAuditLogMaxSize   = 100
+	defaultAuditLogMaxBackup = 1
+)
+
+// AuditPolicyConfigMapName is the name of the ConfigMap with the audit policy generated from
+// KinkControlPlane.
+func AuditPolicyConfigMapName(cluster *clusterv1.Cluster) string {
+	return cluster.Name + "-audit-policy"
+}
+
+// BuildAuditPolicy returns the audit policy of KinkControlPlane in YAML: the inline Policy, or the
+// default policy that audits all the requests at the Metadata level. It returns nil if the audit
+// policy is referenced from a ConfigMap of the users.
+func BuildAuditPolicy(kcp *ctrlv1beta1.KinkControlPlane) ([]byte, error) {
+	audit := kcp.Spec.Audit
+	if audit == nil || audit.PolicyRef != nil {
+		return nil, nil
+	}
+
+	policy := &auditv1.Policy{
+		OmitStages: []auditv1.Stage{auditv1.StageRequestReceived},
+		Rules: []auditv1.PolicyRule{
+			{Level: auditv1.LevelMetadata},
+		},
+	}
+
+	if audit.Policy != nil && len(audit.Policy.Raw) > 0 {
+		policy = &auditv1.Policy{}
+		if err := json.Unmarshal(audit.Policy.Raw, policy); err != nil {
+			return nil, errors.Wrap(err, "failed to parse the audit policy")
+		}
+
+		if len(policy.APIVersion) > 0 && policy.APIVersion != auditv1.SchemeGroupVersion.String() {
+			return nil, fmt.Errorf("unsupported apiVersion %q of the audit policy", policy.APIVersion)
+		}
+		if len(policy.Kind) > 0 && policy.Kind != "Policy" {
+			return nil, fmt.Errorf("unsupported kind %q of the audit policy", policy.Kind)
+		}
+		if len(policy.Rules) == 0 {
+			return nil, fmt.Errorf("the audit policy has no rules")
+		}
+	}
+
+	policy.APIVersion = auditv1.SchemeGroupVersion.String()
+	policy.Kind = "Policy"
+
+	return yaml.Marshal(policy)
+}
+
+// getAuditArgs returns the flags of the audit policy and the audit backends in the Audit of
+// KinkControlPlane, the volumes they need, and the sidecar container shipping the audit log.
+func getAuditArgs(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) ([]string, []v1.Volume, []v1.VolumeMount, []v1.Container) {
+	audit := kcp.Spec.Audit
+	if audit == nil {
+		return nil, nil, nil, nil
+	}
+
+	var args []string
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+	var containers []v1.Container
+
+	policyRef := v1.ConfigMapKeySelector{
+		LocalObjectReference: v1.LocalObjectReference{Name: AuditPolicyConfigMapName(cluster)},
+		Key:                  AuditPolicyDataName,
+	}
+	if audit.PolicyRef != nil {
+		policyRef = *audit.PolicyRef
+	}
+
+	args = append(args, fmt.Sprintf("--audit-policy-file=%s/%s", auditPolicyDir, AuditPolicyDataName))
+	volumes = append(volumes, v1.Volume{
+		Name: auditPolicyVolumeName,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: policyRef.LocalObjectReference,
+				Optional:             policyRef.Optional,
+				Items: []v1.KeyToPath{
+					{
+						Key:  policyRef.Key,
+						Path: AuditPolicyDataName,
+					},
+				},
+			},
+		},
+	})
+	mounts = append(mounts, v1.VolumeMount{
+		Name:      auditPolicyVolumeName,
+		MountPath: auditPolicyDir,
+		ReadOnly:  true,
+	})
+
+	if logBackend := audit.Log; logBackend != nil || audit.Stdout != nil {
+		if logBackend == nil {
+			logBackend = &ctrlv1beta1.AuditLogBackend{
+				MaxSize:   pointer.Int32(defaultAuditLogMaxSize),
+				MaxBackup: pointer.Int32(defaultAuditLogMaxBackup),
+			}
+		}
+
+		args = append(args, fmt.Sprintf("--audit-log-path=%s/%s", auditLogDir, auditLogFileName))
+		if logBackend.MaxAge != nil {
+			args = append(args, fmt.Sprintf("--audit-log-maxage=%d", *logBackend.MaxAge))
+		}
+		if logBackend.MaxBackup != nil {
+			args = append(args, fmt.Sprintf("--audit-log-maxbackup=%d", *logBackend.MaxBackup))
+		}
+		if logBackend.MaxSize != nil {
+			args = append(args, fmt.Sprintf("--audit-log-maxsize=%d", *logBackend.MaxSize))
+		}
+		if logBackend.Compress {
+			args = append(args, "--audit-log-compress=true")
+		}
+		if len(logBackend.Format) > 0 {
+			args = append(args, fmt.Sprintf("--audit-log-format=%s", logBackend.Format))
+		}
+
+		source := v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}
+		if logBackend.Volume != nil {
+			source = *logBackend.Volume
+		}
+		volumes = append(volumes, v1.Volume{
+			Name:         auditLogVolumeName,
+			VolumeSource: source,
+		})
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      auditLogVolumeName,
+			MountPath: auditLogDir,
+		})
+	}
+
+	if stdout := audit.Stdout; stdout != nil {
+		image := defaultAuditStdoutImage
+		if len(stdout.Image) > 0 {
+			image = stdout.Image
+		}
+
+		containers = append(containers, v1.Container{
+			Name:    "audit-log",
+			Image:   image,
+			Command: []string{"tail", "-n", "+1", "-F", auditLogDir + "/" + auditLogFileName},
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      auditLogVolumeName,
+					MountPath: auditLogDir,
+					ReadOnly:  true,
+				},
+			},
+		})
+	}
+
+	if webhook := audit.Webhook; webhook != nil {
+		args = append(args, fmt.Sprintf("--audit-webhook-config-file=%s/%s", auditWebhookDir, webhookKubeconfigName))
+		if len(webhook.Mode) > 0 {
+			args = append(args, fmt.Sprintf("--audit-webhook-mode=%s", webhook.Mode))
+		}
+		if webhook.InitialBackoff != nil {
+			args = append(args, fmt.Sprintf("--audit-webhook-initial-backoff=%s", webhook.InitialBackoff.Duration))
+		}
+
+		volume, mount := getWebhookKubeconfigVolume(auditWebhookVolumeName, auditWebhookDir, &webhook.Kubeconfig)
+		volumes = append(volumes, volume)
+		mounts = append(mounts, mount)
+	}
+
+	return args, volumes, mounts, containers
+}
+
+// getAuditPolicyHash returns the hash of the audit policy: the one generated from KinkControlPlane,
+// or the one referenced from a ConfigMap of the users, as recorded in the status.
+func getAuditPolicyHash(kcp *ctrlv1beta1.KinkControlPlane) string {
+	if audit := kcp.Spec.Audit; audit != nil && audit.PolicyRef != nil {
+		return kcp.Status.AuditPolicyHash
+	}
+
+	policy, err := BuildAuditPolicy(kcp)
+	if err != nil || len(policy) == 0 {
+		return ""
+	}
+
+	return AuditPolicyHash(policy)
+}
+
+// AuditPolicyHash returns the hash of the audit policy.
+func AuditPolicyHash(policy []byte) string {
+	hasher := fnv.New32a()
+	hasher.Write(policy)
+
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
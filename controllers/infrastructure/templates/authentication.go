@@ -0,0 +1,148 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+)
+
+const (
+	oidcCABundleVolumeName = "oidc-ca"
+	oidcCABundleDir        = "/etc/kubernetes/oidc"
+	oidcCABundleFileName   = "ca.crt"
+
+	authnWebhookVolumeName = "authn-webhook"
+	authnWebhookDir        = "/etc/kubernetes/webhooks/authn"
+	webhookKubeconfigName  = "kubeconfig"
+)
+
+// getAuthenticationArgs returns the flags of the authenticators in the Authentication of
+// KinkControlPlane, and the volumes they need.
+func getAuthenticationArgs(kcp *ctrlv1beta1.KinkControlPlane) ([]string, []v1.Volume, []v1.VolumeMount) {
+	authn := kcp.Spec.Authentication
+	if authn == nil {
+		return nil, nil, nil
+	}
+
+	var args []string
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+
+	if oidc := authn.OIDC; oidc != nil {
+		args = append(args,
+			fmt.Sprintf("--oidc-issuer-url=%s", oidc.IssuerURL),
+			fmt.Sprintf("--oidc-client-id=%s", oidc.ClientID))
+
+		if len(oidc.UsernameClaim) > 0 {
+			args = append(args, fmt.Sprintf("--oidc-username-claim=%s", oidc.UsernameClaim))
+		}
+		if len(oidc.UsernamePrefix) > 0 {
+			args = append(args, fmt.Sprintf("--oidc-username-prefix=%s", oidc.UsernamePrefix))
+		}
+		if len(oidc.GroupsClaim) > 0 {
+			args = append(args, fmt.Sprintf("--oidc-groups-claim=%s", oidc.GroupsClaim))
+		}
+		if len(oidc.GroupsPrefix) > 0 {
+			args = append(args, fmt.Sprintf("--oidc-groups-prefix=%s", oidc.GroupsPrefix))
+		}
+		if len(oidc.SigningAlgs) > 0 {
+			args = append(args, fmt.Sprintf("--oidc-signing-algs=%s", strings.Join(oidc.SigningAlgs, ",")))
+		}
+
+		// Keep the flags in order, so the template hash is stable.
+		var claims []string
+		for claim, value := range oidc.RequiredClaims {
+			claims = append(claims, fmt.Sprintf("--oidc-required-claim=%s=%s", claim, value))
+		}
+		sort.Strings(claims)
+		args = append(args, claims...)
+
+		if oidc.CABundle != nil {
+			args = append(args, fmt.Sprintf("--oidc-ca-file=%s/%s", oidcCABundleDir, oidcCABundleFileName))
+
+			volumes = append(volumes, v1.Volume{
+				Name: oidcCABundleVolumeName,
+				VolumeSource: v1.VolumeSource{
+					ConfigMap: &v1.ConfigMapVolumeSource{
+						LocalObjectReference: oidc.CABundle.LocalObjectReference,
+						Optional:             oidc.CABundle.Optional,
+						Items: []v1.KeyToPath{
+							{
+								Key:  oidc.CABundle.Key,
+								Path: oidcCABundleFileName,
+							},
+						},
+					},
+				},
+			})
+			mounts = append(mounts, v1.VolumeMount{
+				Name:      oidcCABundleVolumeName,
+				MountPath: oidcCABundleDir,
+				ReadOnly:  true,
+			})
+		}
+	}
+
+	if webhook := authn.Webhook; webhook != nil {
+		args = append(args, fmt.Sprintf("--authentication-token-webhook-config-file=%s/%s", authnWebhookDir, webhookKubeconfigName))
+		if webhook.CacheTTL != nil {
+			args = append(args, fmt.Sprintf("--authentication-token-webhook-cache-ttl=%s", webhook.CacheTTL.Duration))
+		}
+		if len(webhook.Version) > 0 {
+			args = append(args, fmt.Sprintf("--authentication-token-webhook-version=%s", webhook.Version))
+		}
+
+		volume, mount := getWebhookKubeconfigVolume(authnWebhookVolumeName, authnWebhookDir, &webhook.Kubeconfig)
+		volumes = append(volumes, volume)
+		mounts = append(mounts, mount)
+	}
+
+	return args, volumes, mounts
+}
+
+// getWebhookKubeconfigVolume mounts the kubeconfig of a webhook from the key of a Secret.
+func getWebhookKubeconfigVolume(name, dir string, kubeconfig *v1.SecretKeySelector) (v1.Volume, v1.VolumeMount) {
+	volume := v1.Volume{
+		Name: name,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: kubeconfig.Name,
+				Optional:   kubeconfig.Optional,
+				Items: []v1.KeyToPath{
+					{
+						Key:  kubeconfig.Key,
+						Path: webhookKubeconfigName,
+					},
+				},
+			},
+		},
+	}
+
+	mount := v1.VolumeMount{
+		Name:      name,
+		MountPath: dir,
+		ReadOnly:  true,
+	}
+
+	return volume, mount
+}
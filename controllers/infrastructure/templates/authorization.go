@@ -0,0 +1,66 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+)
+
+const (
+	authzWebhookVolumeName = "authz-webhook"
+	authzWebhookDir        = "/etc/kubernetes/webhooks/authz"
+)
+
+// getAuthorizationArgs returns the flags of the authorizers in the Authorization of
+// KinkControlPlane, and the volumes they need. The Node and RBAC authorizers always come first,
+// so the nodes and the components of the cluster are not blocked by a remote service.
+func getAuthorizationArgs(kcp *ctrlv1beta1.KinkControlPlane) ([]string, []v1.Volume, []v1.VolumeMount) {
+	modes := []string{"Node", "RBAC"}
+
+	var args []string
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+
+	if authz := kcp.Spec.Authorization; authz != nil && authz.Webhook != nil {
+		webhook := authz.Webhook
+		modes = append(modes, "Webhook")
+
+		args = append(args, fmt.Sprintf("--authorization-webhook-config-file=%s/%s", authzWebhookDir, webhookKubeconfigName))
+		if webhook.AuthorizedTTL != nil {
+			args = append(args, fmt.Sprintf("--authorization-webhook-cache-authorized-ttl=%s", webhook.AuthorizedTTL.Duration))
+		}
+		if webhook.UnauthorizedTTL != nil {
+			args = append(args, fmt.Sprintf("--authorization-webhook-cache-unauthorized-ttl=%s", webhook.UnauthorizedTTL.Duration))
+		}
+		if len(webhook.Version) > 0 {
+			args = append(args, fmt.Sprintf("--authorization-webhook-version=%s", webhook.Version))
+		}
+
+		volume, mount := getWebhookKubeconfigVolume(authzWebhookVolumeName, authzWebhookDir, &webhook.Kubeconfig)
+		volumes = append(volumes, volume)
+		mounts = append(mounts, mount)
+	}
+
+	args = append([]string{fmt.Sprintf("--authorization-mode=%s", strings.Join(modes, ","))}, args...)
+
+	return args, volumes, mounts
+}
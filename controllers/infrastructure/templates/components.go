@@ -0,0 +1,362 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+const (
+	featureGatesFlagName = "feature-gates"
+
+	// kinkKeyPrefix is the prefix of the labels and annotations of kink.
+	kinkKeyPrefix = "kink.openbce.io/"
+)
+
+// protectedMetadataFields are the fields of the pod metadata that the PodTemplate of the
+// components can not override.
+var protectedMetadataFields = []string{
+	"name",
+	"generateName",
+	"namespace",
+	"uid",
+	"ownerReferences",
+	"finalizers",
+}
+
+var flagNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// managedFlags are the flags that kink must control for each role: the certificates, keys and
+// kubeconfigs it mounts, the addresses and ports that the other components, the Services and the
+// NetworkPolicies rely on, and the files generated from KinkControlPlane.
+var managedFlags = map[infrav1beta1.ControlPlaneRole]sets.String{
+	infrav1beta1.ApiServer: sets.NewString(
+		"advertise-address",
+		"bind-address",
+		"secure-port",
+		"etcd-servers",
+		"service-cluster-ip-range",
+		"client-ca-file",
+		"requestheader-client-ca-file",
+		"proxy-client-cert-file",
+		"proxy-client-key-file",
+		"kubelet-client-certificate",
+		"kubelet-client-key",
+		"tls-cert-file",
+		"tls-private-key-file",
+		"service-account-issuer",
+		"service-account-key-file",
+		"service-account-signing-key-file",
+		"oidc-ca-file",
+		"authentication-token-webhook-config-file",
+		"authorization-webhook-config-file",
+		"audit-policy-file",
+		"audit-log-path",
+		"audit-webhook-config-file",
+		"encryption-provider-config",
+		"admission-control-config-file",
+	),
+	infrav1beta1.ControllerManager: sets.NewString(
+		"bind-address",
+		"secure-port",
+		"kubeconfig",
+		"authentication-kubeconfig",
+		"authorization-kubeconfig",
+		"client-ca-file",
+		"requestheader-client-ca-file",
+		"cluster-signing-cert-file",
+		"cluster-signing-key-file",
+		"root-ca-file",
+		"service-account-private-key-file",
+		"service-cluster-ip-range",
+		"cluster-cidr",
+	),
+	infrav1beta1.Scheduler: sets.NewString(
+		"bind-address",
+		"secure-port",
+		"kubeconfig",
+		"authentication-kubeconfig",
+		"authorization-kubeconfig",
+	),
+	infrav1beta1.ETCD: sets.NewString(
+		"data-dir",
+		"listen-client-urls",
+		"advertise-client-urls",
+		"listen-peer-urls",
+		"initial-advertise-peer-urls",
+	),
+}
+
+// getComponent returns the customization of the component in the role.
+func getComponent(kcp *ctrlv1beta1.KinkControlPlane, role infrav1beta1.ControlPlaneRole) *ctrlv1beta1.ControlPlaneComponent {
+	switch role {
+	case infrav1beta1.ApiServer:
+		return kcp.Spec.APIServer
+	case infrav1beta1.ControllerManager:
+		return kcp.Spec.ControllerManager
+	case infrav1beta1.Scheduler:
+		return kcp.Spec.Scheduler
+	case infrav1beta1.ETCD:
+		return kcp.Spec.Etcd
+	}
+
+	return nil
+}
+
+// ValidateComponents checks the customization of the components in KinkControlPlane: the extra
+// flags must not be controlled by kink, the feature gates are only set by FeatureGates, and the
+// PodTemplate must not override the protected fields.
+func ValidateComponents(kcp *ctrlv1beta1.KinkControlPlane) error {
+	var errs []error
+
+	for _, role := range infrav1beta1.ControlPlaneRoles {
+		component := getComponent(kcp, role)
+		if component == nil {
+			continue
+		}
+
+		var names []string
+		for name := range component.ExtraArgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			switch {
+			case !flagNameRegexp.MatchString(name):
+				errs = append(errs, fmt.Errorf("invalid flag name %q in the extraArgs of %s", name, role))
+			case name == featureGatesFlagName:
+				errs = append(errs, fmt.Errorf("the feature gates of %s must be set by featureGates instead of extraArgs", role))
+			case managedFlags[role].Has(name):
+				errs = append(errs, fmt.Errorf("the flag --%s of %s is managed by kink", name, role))
+			}
+		}
+
+		if role == infrav1beta1.ETCD && len(component.FeatureGates) > 0 {
+			errs = append(errs, fmt.Errorf("etcd does not support featureGates"))
+		}
+
+		errs = append(errs, validatePodTemplate(role, component.PodTemplate)...)
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// getComponentArgs merges the extra flags and the feature gates of the component in the role
+// into the flags set by kink: an extra flag replaces all the flags with the same name in place,
+// and the others are appended in order. The values are quoted for the shell running the command.
+func getComponentArgs(kcp *ctrlv1beta1.KinkControlPlane, role infrav1beta1.ControlPlaneRole, args []string) []string {
+	component := getComponent(kcp, role)
+	if component == nil {
+		return args
+	}
+
+	extra := map[string]string{}
+	for name, value := range component.ExtraArgs {
+		extra[name] = fmt.Sprintf("--%s=%s", name, shellQuote(value))
+	}
+
+	if len(component.FeatureGates) > 0 {
+		// The feature gates set by kink are kept, unless the component sets them too.
+		featureGates := map[string]string{}
+		for _, arg := range args {
+			if value := strings.TrimPrefix(arg, "--"+featureGatesFlagName+"="); value != arg {
+				for _, gate := range strings.Split(value, ",") {
+					kv := strings.SplitN(gate, "=", 2)
+					if len(kv) == 2 {
+						featureGates[kv[0]] = kv[1]
+					}
+				}
+			}
+		}
+		for name, enabled := range component.FeatureGates {
+			featureGates[name] = strconv.FormatBool(enabled)
+		}
+
+		var gates []string
+		for name, enabled := range featureGates {
+			gates = append(gates, fmt.Sprintf("%s=%s", name, enabled))
+		}
+		sort.Strings(gates)
+		extra[featureGatesFlagName] = fmt.Sprintf("--%s=%s", featureGatesFlagName, shellQuote(strings.Join(gates, ",")))
+	}
+
+	var res []string
+	for _, arg := range args {
+		name := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)[0]
+		flag, found := extra[name]
+		if !found || !strings.HasPrefix(arg, "--") {
+			res = append(res, arg)
+			continue
+		}
+
+		// Only the first one is replaced, the others are dropped.
+		if len(flag) > 0 {
+			res = append(res, flag)
+			extra[name] = ""
+		}
+	}
+
+	// Keep the flags in order, so the template hash is stable.
+	var appended []string
+	for _, flag := range extra {
+		if len(flag) > 0 {
+			appended = append(appended, flag)
+		}
+	}
+	sort.Strings(appended)
+
+	return append(res, appended...)
+}
+
+// setComponent adds the extra environment variables, volumes and volume mounts of the component
+// in the role to the pod, they are only for its first container; and then applies the
+// PodTemplate of the component to the pod.
+func setComponent(pod *v1.Pod, kcp *ctrlv1beta1.KinkControlPlane, role infrav1beta1.ControlPlaneRole) {
+	component := getComponent(kcp, role)
+	if component == nil || len(pod.Spec.Containers) == 0 {
+		return
+	}
+
+	c := &pod.Spec.Containers[0]
+	c.Env = append(append([]v1.EnvVar{}, c.Env...), component.ExtraEnv...)
+	c.VolumeMounts = append(append([]v1.VolumeMount{}, c.VolumeMounts...), component.ExtraVolumeMounts...)
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, component.ExtraVolumes...)
+
+	applyPodTemplate(pod, component.PodTemplate)
+}
+
+// validatePodTemplate checks that the PodTemplate of the component in the role is a strategic
+// merge patch of PodTemplateSpec, which does not override the protected fields.
+func validatePodTemplate(role infrav1beta1.ControlPlaneRole, podTemplate *runtime.RawExtension) []error {
+	if podTemplate == nil || len(podTemplate.Raw) == 0 {
+		return nil
+	}
+
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(podTemplate.Raw, &patch); err != nil {
+		return []error{fmt.Errorf("invalid podTemplate of %s: %v", role, err)}
+	}
+
+	var errs []error
+	if hasPatchDirective(patch) {
+		errs = append(errs, fmt.Errorf("the podTemplate of %s must not contain the directives of strategic merge patch", role))
+	}
+
+	if meta, ok := patch["metadata"].(map[string]interface{}); ok {
+		for _, field := range protectedMetadataFields {
+			if _, found := meta[field]; found {
+				errs = append(errs, fmt.Errorf("metadata.%s can not be overridden by the podTemplate of %s", field, role))
+			}
+		}
+
+		for _, field := range []string{"labels", "annotations"} {
+			values, _ := meta[field].(map[string]interface{})
+			var keys []string
+			for key := range values {
+				if key == clusterv1.ClusterLabelName || strings.HasPrefix(key, kinkKeyPrefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				errs = append(errs, fmt.Errorf("metadata.%s[%s] can not be overridden by the podTemplate of %s", field, key, role))
+			}
+		}
+	}
+
+	// The patch must apply to a PodTemplateSpec, and result in a valid one.
+	patched, err := strategicpatch.StrategicMergePatch([]byte("{}"), podTemplate.Raw, v1.PodTemplateSpec{})
+	if err == nil {
+		err = json.Unmarshal(patched, &v1.PodTemplateSpec{})
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid podTemplate of %s: %v", role, err))
+	}
+
+	return errs
+}
+
+// applyPodTemplate applies the PodTemplate of a component to the pod as a strategic merge patch;
+// it was checked by ValidateComponents.
+func applyPodTemplate(pod *v1.Pod, podTemplate *runtime.RawExtension) {
+	if podTemplate == nil || len(podTemplate.Raw) == 0 {
+		return
+	}
+
+	original, err := json.Marshal(&v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec})
+	if err != nil {
+		return
+	}
+
+	patched, err := strategicpatch.StrategicMergePatch(original, podTemplate.Raw, v1.PodTemplateSpec{})
+	if err != nil {
+		return
+	}
+
+	tmpl := &v1.PodTemplateSpec{}
+	if err := json.Unmarshal(patched, tmpl); err != nil {
+		return
+	}
+
+	pod.ObjectMeta = tmpl.ObjectMeta
+	pod.Spec = tmpl.Spec
+}
+
+// hasPatchDirective returns true if any key of the patch is a directive of strategic merge
+// patch, e.g. "$patch" or "$retainKeys".
+func hasPatchDirective(patch interface{}) bool {
+	switch p := patch.(type) {
+	case map[string]interface{}:
+		for key, value := range p {
+			if strings.HasPrefix(key, "$") || hasPatchDirective(value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, value := range p {
+			if hasPatchDirective(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// shellQuote quotes the value as a single word of the shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
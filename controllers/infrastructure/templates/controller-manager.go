@@ -26,14 +26,17 @@ import (
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
 )
 
-func ControllerManagerPodTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) *v1.Pod {
+func ControllerManagerPodTemplate(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) *v1.Pod {
 	owner := metav1.NewControllerRef(machine,
 		infrav1beta1.GroupVersion.WithKind("KinkMachine"))
 
-	volumes, mounts := getSecretVolumes(cluster)
+	volumes, mounts := getSecretVolumes(cluster, infrav1beta1.ControllerManager)
+	kubeconfig := getKubeconfigPath(secrets.ControllerManagerName)
 
 	serviceDIDR := "192.168.0.0/24"
 	if len(cluster.Spec.ClusterNetwork.Services.CIDRBlocks) != 0 {
@@ -45,7 +48,30 @@ func ControllerManagerPodTemplate(cluster *clusterv1.Cluster, machine *infrav1be
 		podCIDR = cluster.Spec.ClusterNetwork.Pods.CIDRBlocks[0]
 	}
 
-	return &v1.Pod{
+	args := []string{
+		"kube-controller-manager",
+		"--allocate-node-cidrs=true",
+		fmt.Sprintf("--authentication-kubeconfig=%s", kubeconfig),
+		fmt.Sprintf("--authorization-kubeconfig=%s", kubeconfig),
+		"--bind-address=${host_ip}",
+		"--client-ca-file=/etc/kubernetes/pki/ca/tls.crt",
+		fmt.Sprintf("--cluster-cidr=%s", podCIDR),
+		"--cluster-name=kubernetes",
+		"--cluster-signing-cert-file=/etc/kubernetes/pki/ca/tls.crt",
+		"--cluster-signing-key-file=/etc/kubernetes/pki/ca/tls.key",
+		"--controllers=*,bootstrapsigner,tokencleaner",
+		fmt.Sprintf("--kubeconfig=%s", kubeconfig),
+		"--leader-elect=true",
+		"--requestheader-client-ca-file=/etc/kubernetes/pki/front-proxy-ca/tls.crt",
+		"--root-ca-file=/etc/kubernetes/pki/ca/tls.crt",
+		"--service-account-private-key-file=/etc/kubernetes/pki/sa/tls.key",
+		fmt.Sprintf("--service-cluster-ip-range=%s", serviceDIDR),
+		"--use-service-account-credentials=true",
+	}
+	args = append(args, getSecurityProfileArgs(kcp)...)
+	args = getComponentArgs(kcp, infrav1beta1.ControllerManager, args)
+
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      names.SimpleNameGenerator.GenerateName(cluster.Name + "-controller-manager-"),
 			Namespace: cluster.Namespace,
@@ -53,44 +79,32 @@ func ControllerManagerPodTemplate(cluster *clusterv1.Cluster, machine *infrav1be
 				clusterv1.ClusterLabelName:             cluster.Name,
 				infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ControllerManager),
 			},
+			Annotations: map[string]string{
+				secrets.SASigningKeyIDAnnotationName: getSASigningKeyID(kcp),
+			},
 			OwnerReferences: []metav1.OwnerReference{*owner},
 		},
 		Spec: v1.PodSpec{
 			RestartPolicy: v1.RestartPolicyAlways,
-			HostNetwork:   true,
-			DNSPolicy:     v1.DNSClusterFirstWithHostNet,
 			Containers: []v1.Container{
 				{
-					Name:    "controller-manager",
-					Image:   "openbce/kube-controller-manager:v1.24.1",
-					Env:     []v1.EnvVar{hostIPEnvVar},
-					Command: []string{"/bin/sh", "-c"},
-					Args: []string{strings.Join([]string{
-						"kube-controller-manager",
-						"--allocate-node-cidrs=true",
-						"--authentication-kubeconfig=/etc/kubernetes/controller-manager.conf",
-						"--authorization-kubeconfig=/etc/kubernetes/controller-manager.conf",
-						"--bind-address=${host_ip}",
-						"--client-ca-file=/etc/kubernetes/pki/ca.crt",
-						fmt.Sprintf("--cluster-cidr=%s", podCIDR),
-						"--cluster-name=kubernetes",
-						"--cluster-signing-cert-file=/etc/kubernetes/pki/ca.crt",
-						"--cluster-signing-key-file=/etc/kubernetes/pki/ca.key",
-						"--controllers=*,bootstrapsigner,tokencleaner",
-						"--kubeconfig=/etc/kubernetes/controller-manager.conf",
-						"--leader-elect=true",
-						"--requestheader-client-ca-file=/etc/kubernetes/pki/front-proxy-ca.crt",
-						"--root-ca-file=/etc/kubernetes/pki/ca.crt",
-						"--service-account-private-key-file=/etc/kubernetes/pki/sa.key",
-						fmt.Sprintf("--service-cluster-ip-range=%s", serviceDIDR),
-						"--use-service-account-credentials=true",
-					},
-						" "),
-					},
+					Name:         "controller-manager",
+					Image:        getComponentImage("kube-controller-manager", machine),
+					Env:          []v1.EnvVar{hostIPEnvVar},
+					Command:      []string{"/bin/sh", "-c"},
+					Args:         []string{strings.Join(args, " ")},
 					VolumeMounts: mounts,
 				},
 			},
 			Volumes: volumes,
 		},
 	}
+
+	setScheduling(pod, cluster, kcp, infrav1beta1.ControllerManager)
+	setResources(pod, kcp, infrav1beta1.ControllerManager)
+	setProbes(pod, kcp, infrav1beta1.ControllerManager)
+	setComponent(pod, kcp, infrav1beta1.ControllerManager)
+	setPodSecurity(pod, kcp)
+
+	return pod
 }
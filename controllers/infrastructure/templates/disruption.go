@@ -0,0 +1,84 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// PodDisruptionBudgetName is the name of the PodDisruptionBudget of the control plane pods in the
+// role.
+func PodDisruptionBudgetName(cluster *clusterv1.Cluster, role infrav1beta1.ControlPlaneRole) string {
+	return cluster.Name + "-" + string(role)
+}
+
+// PodDisruptionBudgetTemplates returns the PodDisruptionBudgets of the control plane pods in each
+// role: at most one etcd pod is disrupted at a time, as each etcd pod serves the data of its own
+// KinkMachine, and at least one pod of the other roles is available. It returns nil if KinkControlPlane has no more than one replica, as its only pods can not be protected.
+func PodDisruptionBudgetTemplates(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) map[infrav1beta1.ControlPlaneRole]*policyv1.PodDisruptionBudget {
+	var replicas int
+	if kcp.Spec.Replicas != nil {
+		replicas = int(*kcp.Spec.Replicas)
+	}
+	if replicas <= 1 {
+		return nil
+	}
+
+	res := map[infrav1beta1.ControlPlaneRole]*policyv1.PodDisruptionBudget{}
+
+	one := intstr.FromInt(1)
+
+	res[infrav1beta1.ETCD] = buildPodDisruptionBudget(cluster, kcp, infrav1beta1.ETCD,
+		policyv1.PodDisruptionBudgetSpec{MaxUnavailable: &one})
+	res[infrav1beta1.ApiServer] = buildPodDisruptionBudget(cluster, kcp, infrav1beta1.ApiServer,
+		policyv1.PodDisruptionBudgetSpec{MinAvailable: &one})
+	res[infrav1beta1.ControllerManager] = buildPodDisruptionBudget(cluster, kcp, infrav1beta1.ControllerManager,
+		policyv1.PodDisruptionBudgetSpec{MinAvailable: &one})
+	res[infrav1beta1.Scheduler] = buildPodDisruptionBudget(cluster, kcp, infrav1beta1.Scheduler,
+		policyv1.PodDisruptionBudgetSpec{MinAvailable: &one})
+
+	return res
+}
+
+func buildPodDisruptionBudget(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane,
+	role infrav1beta1.ControlPlaneRole, spec policyv1.PodDisruptionBudgetSpec) *policyv1.PodDisruptionBudget {
+	owner := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
+
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            PodDisruptionBudgetName(cluster, role),
+			Namespace:       cluster.Namespace,
+			Labels:          roleLabels(cluster, role),
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable:   spec.MinAvailable,
+			MaxUnavailable: spec.MaxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: roleLabels(cluster, role),
+			},
+		},
+	}
+}
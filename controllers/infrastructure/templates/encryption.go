@@ -0,0 +1,190 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	hashutil "k8s.io/kubernetes/pkg/util/hash"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
+)
+
+// EncryptionConfigAnnotationName records the hash of the encryption configuration that the API
+// Server pod was started with, so the pod is rolled when the keys are rotated, and the rotation
+// goes on once all the API Server pods have loaded it.
+const EncryptionConfigAnnotationName = "kink.openbce.io/encryption-config"
+
+const (
+	encryptionVolumeName = "encryption-config"
+	encryptionDir        = "/etc/kubernetes/encryption"
+
+	kmsSocketVolumeName = "kms-socket"
+	kmsConfigVolumeName = "kms-config"
+	kmsConfigDir        = "/etc/kms-plugin"
+	kmsSocketEnvName    = "KMS_SOCKET_PATH"
+)
+
+// EncryptionConfigHash returns the hash of the encryption configuration of the given state. The
+// plaintext marker of the keys is excluded, as the identity provider is always configured.
+func EncryptionConfigHash(status *ctrlv1beta1.EncryptionStatus) string {
+	if status == nil || len(status.Keys) == 0 {
+		return ""
+	}
+
+	var keys []string
+	for _, k := range status.Keys {
+		if k.Provider == ctrlv1beta1.IdentityEncryption {
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%s/%s/%s", k.Name, k.Provider, k.State))
+	}
+
+	hasher := fnv.New32a()
+	hashutil.DeepHashObject(hasher, struct {
+		Resources        []string
+		PendingResources []string
+		Keys             []string
+	}{
+		Resources:        status.Resources,
+		PendingResources: status.PendingResources,
+		Keys:             keys,
+	})
+
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// getEncryptionArgs returns the flag and the volume of the encryption configuration, once the
+// first key is generated, and the sidecar container of the KMS plugin while its keys are used; the
+// KMSv2 feature gate is enabled for the keys of KMS v2 if the API Server has not graduated it.
+func getEncryptionArgs(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) ([]string, []v1.Volume, []v1.VolumeMount, []v1.Container) {
+	status := kcp.Status.Encryption
+	if status == nil || len(status.Keys) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	args := []string{
+		fmt.Sprintf("--encryption-provider-config=%s/%s", encryptionDir, secrets.EncryptionConfigDataName),
+	}
+
+	volumes := []v1.Volume{
+		{
+			Name: encryptionVolumeName,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: secrets.EncryptionSecretName(cluster),
+					Items: []v1.KeyToPath{
+						{
+							Key:  secrets.EncryptionConfigDataName,
+							Path: secrets.EncryptionConfigDataName,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	mounts := []v1.VolumeMount{
+		{
+			Name:      encryptionVolumeName,
+			MountPath: encryptionDir,
+			ReadOnly:  true,
+		},
+	}
+
+	for _, k := range status.Keys {
+		if k.KMSAPIVersion == ctrlv1beta1.KMSAPIVersionV2 && GetKubernetesVersion(machine).LessThan(kmsV2GAVersion) {
+			args = append(args, fmt.Sprintf("--%s=KMSv2=true", featureGatesFlagName))
+			break
+		}
+	}
+
+	var containers []v1.Container
+	for _, k := range status.Keys {
+		if k.Provider != ctrlv1beta1.KMSEncryption {
+			continue
+		}
+
+		if spec := kcp.Spec.Encryption; spec != nil && spec.KMS != nil {
+			kmsVolumes, kmsMount, kmsContainer := getKMSPlugin(spec.KMS)
+			volumes = append(volumes, kmsVolumes...)
+			mounts = append(mounts, kmsMount)
+			containers = append(containers, kmsContainer)
+		}
+		break
+	}
+
+	return args, volumes, mounts, containers
+}
+
+// getKMSPlugin returns the sidecar container of the KMS plugin, and the socket volume shared
+// with API Server.
+func getKMSPlugin(plugin *ctrlv1beta1.KMSPlugin) ([]v1.Volume, v1.VolumeMount, v1.Container) {
+	volumes := []v1.Volume{
+		{
+			Name: kmsSocketVolumeName,
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+
+	mount := v1.VolumeMount{
+		Name:      kmsSocketVolumeName,
+		MountPath: secrets.KMSSocketDir,
+	}
+
+	container := v1.Container{
+		Name:  "kms-plugin",
+		Image: plugin.Image,
+		Args:  plugin.Args,
+		Env:   plugin.Env,
+	}
+	if plugin.Container != nil {
+		container = *plugin.Container.DeepCopy()
+		if len(container.Name) == 0 {
+			container.Name = "kms-plugin"
+		}
+	}
+
+	container.Env = append(container.Env, v1.EnvVar{
+		Name:  kmsSocketEnvName,
+		Value: secrets.KMSSocketPath,
+	})
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+
+	if plugin.Config != nil {
+		volumes = append(volumes, v1.Volume{
+			Name:         kmsConfigVolumeName,
+			VolumeSource: *plugin.Config,
+		})
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      kmsConfigVolumeName,
+			MountPath: kmsConfigDir,
+			ReadOnly:  true,
+		})
+	}
+
+	return volumes, mount, container
+}
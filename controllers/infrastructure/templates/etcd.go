@@ -28,16 +28,20 @@ import (
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
 )
 
 const (
 	EtcdDefaultPort = 2379
+
+	etcdDataVolumeName = "data"
+	etcdDataDir        = "/var/lib/etcd"
 )
 
-func EtcdServiceTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) *v1.Service {
-	owner := metav1.NewControllerRef(machine,
-		infrav1beta1.GroupVersion.WithKind("KinkMachine"))
+func EtcdServiceTemplate(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) *v1.Service {
+	owner := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
 
 	return &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -68,7 +72,7 @@ func EtcdServiceTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkM
 	}
 }
 
-func EtcdPodTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) *v1.Pod {
+func EtcdPodTemplate(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) *v1.Pod {
 	owner := metav1.OwnerReference{
 		APIVersion:         infrav1beta1.GroupVersion.String(),
 		Kind:               "KinkMachine",
@@ -78,11 +82,30 @@ func EtcdPodTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachi
 		BlockOwnerDeletion: pointer.BoolPtr(true),
 	}
 
-	volumes, mounts := getSecretVolumes(cluster)
+	volumes, mounts := getSecretVolumes(cluster, infrav1beta1.ETCD)
+
+	// The root filesystem may be read-only, keep the data of etcd in an emptyDir.
+	volumes = append(volumes, v1.Volume{
+		Name: etcdDataVolumeName,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		},
+	})
+	mounts = append(mounts, v1.VolumeMount{
+		Name:      etcdDataVolumeName,
+		MountPath: etcdDataDir,
+	})
+
+	args := getComponentArgs(kcp, infrav1beta1.ETCD, []string{
+		"etcd",
+		fmt.Sprintf("--data-dir=%s", etcdDataDir),
+		fmt.Sprintf("--advertise-client-urls=http://${host_ip}:%d", EtcdDefaultPort),
+		fmt.Sprintf("--listen-client-urls=http://${host_ip}:%d,http://127.0.0.1:%d", EtcdDefaultPort, EtcdDefaultPort),
+	})
 
 	podName := names.SimpleNameGenerator.GenerateName(cluster.Name + "-etcd-")
 
-	return &v1.Pod{
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podName,
 			Namespace: cluster.Namespace,
@@ -94,23 +117,25 @@ func EtcdPodTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachi
 		},
 		Spec: v1.PodSpec{
 			RestartPolicy: v1.RestartPolicyAlways,
-			HostNetwork:   true,
 			Containers: []v1.Container{
 				{
-					Name:    "etcd",
-					Image:   "openbce/etcd:3.5.3-0",
-					Env:     []v1.EnvVar{hostIPEnvVar},
-					Command: []string{"/bin/sh", "-c"},
-					Args: []string{strings.Join([]string{
-						"etcd",
-						fmt.Sprintf("--advertise-client-urls=http://${host_ip}:%d", EtcdDefaultPort),
-						fmt.Sprintf("--listen-client-urls=http://${host_ip}:%d,http://127.0.0.1:%d", EtcdDefaultPort, EtcdDefaultPort)},
-						" "),
-					},
+					Name:         "etcd",
+					Image:        "openbce/etcd:3.5.3-0",
+					Env:          []v1.EnvVar{hostIPEnvVar},
+					Command:      []string{"/bin/sh", "-c"},
+					Args:         []string{strings.Join(args, " ")},
 					VolumeMounts: mounts,
 				},
 			},
 			Volumes: volumes,
 		},
 	}
+
+	setScheduling(pod, cluster, kcp, infrav1beta1.ETCD)
+	setResources(pod, kcp, infrav1beta1.ETCD)
+	setProbes(pod, kcp, infrav1beta1.ETCD)
+	setComponent(pod, kcp, infrav1beta1.ETCD)
+	setPodSecurity(pod, kcp)
+
+	return pod
 }
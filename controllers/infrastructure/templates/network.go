@@ -0,0 +1,178 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+const (
+	etcdPeerPort = 2380
+
+	// The default secure ports of the controller manager and the scheduler, which serve their
+	// metrics and health checks.
+	controllerManagerSecurePort = 10257
+	schedulerSecurePort         = 10259
+)
+
+// managerLabels are the labels of the kink controller manager and its namespace, see
+// config/manager.
+var managerLabels = map[string]string{
+	"control-plane": "controller-manager",
+}
+
+// NetworkPolicyName is the name of the NetworkPolicy of the control plane pods in the role.
+func NetworkPolicyName(cluster *clusterv1.Cluster, role infrav1beta1.ControlPlaneRole) string {
+	return cluster.Name + "-" + string(role)
+}
+
+// NetworkPolicyTemplates returns the NetworkPolicies of the control plane pods in each role,
+// according to the NetworkPolicy of KinkControlPlane; it returns nil if they are disabled.
+func NetworkPolicyTemplates(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane) map[infrav1beta1.ControlPlaneRole]*networkingv1.NetworkPolicy {
+	spec := kcp.Spec.NetworkPolicy
+	if spec == nil {
+		spec = &ctrlv1beta1.NetworkPolicySpec{}
+	}
+	if spec.Disabled {
+		return nil
+	}
+
+	rolePeer := func(role infrav1beta1.ControlPlaneRole) networkingv1.NetworkPolicyPeer {
+		return networkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: roleLabels(cluster, role),
+			},
+		}
+	}
+
+	// The control plane pods of the cluster, e.g. the controller manager and the scheduler.
+	clusterPeer := networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				clusterv1.ClusterLabelName: cluster.Name,
+			},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{
+					Key:      infrav1beta1.ControlPlaneRoleLabelName,
+					Operator: metav1.LabelSelectorOpExists,
+				},
+			},
+		},
+	}
+
+	// The kink controller manager, which talks to the API Server, e.g. to re-encrypt the resources
+	// and to set up the RBAC of the discovery documents.
+	managerPeer := networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: managerLabels,
+		},
+		PodSelector: &metav1.LabelSelector{
+			MatchLabels: managerLabels,
+		},
+	}
+
+	var apiServerFrom []networkingv1.NetworkPolicyPeer
+	if len(spec.AllowedCIDRs) > 0 || len(spec.IngressFrom) > 0 {
+		apiServerFrom = append(apiServerFrom, clusterPeer, managerPeer)
+		for _, cidr := range spec.AllowedCIDRs {
+			apiServerFrom = append(apiServerFrom, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		}
+		apiServerFrom = append(apiServerFrom, spec.IngressFrom...)
+	}
+
+	res := map[infrav1beta1.ControlPlaneRole]*networkingv1.NetworkPolicy{}
+
+	res[infrav1beta1.ETCD] = buildNetworkPolicy(cluster, kcp, infrav1beta1.ETCD,
+		networkingv1.NetworkPolicyIngressRule{
+			Ports: tcpPorts(EtcdDefaultPort),
+			From:  []networkingv1.NetworkPolicyPeer{rolePeer(infrav1beta1.ApiServer)},
+		},
+		networkingv1.NetworkPolicyIngressRule{
+			Ports: tcpPorts(EtcdDefaultPort, etcdPeerPort),
+			From:  []networkingv1.NetworkPolicyPeer{rolePeer(infrav1beta1.ETCD)},
+		})
+	res[infrav1beta1.ApiServer] = buildNetworkPolicy(cluster, kcp, infrav1beta1.ApiServer,
+		networkingv1.NetworkPolicyIngressRule{
+			Ports: tcpPorts(ApiServerDefaultPort),
+			From:  apiServerFrom,
+		})
+	res[infrav1beta1.ControllerManager] = buildNetworkPolicy(cluster, kcp, infrav1beta1.ControllerManager,
+		networkingv1.NetworkPolicyIngressRule{
+			Ports: tcpPorts(controllerManagerSecurePort),
+			From:  spec.MetricsFrom,
+		})
+	res[infrav1beta1.Scheduler] = buildNetworkPolicy(cluster, kcp, infrav1beta1.Scheduler,
+		networkingv1.NetworkPolicyIngressRule{
+			Ports: tcpPorts(schedulerSecurePort),
+			From:  spec.MetricsFrom,
+		})
+
+	return res
+}
+
+func buildNetworkPolicy(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane,
+	role infrav1beta1.ControlPlaneRole, rules ...networkingv1.NetworkPolicyIngressRule) *networkingv1.NetworkPolicy {
+	owner := metav1.NewControllerRef(kcp,
+		ctrlv1beta1.GroupVersion.WithKind("KinkControlPlane"))
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            NetworkPolicyName(cluster, role),
+			Namespace:       cluster.Namespace,
+			Labels:          roleLabels(cluster, role),
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: roleLabels(cluster, role),
+			},
+			Ingress:     rules,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+func roleLabels(cluster *clusterv1.Cluster, role infrav1beta1.ControlPlaneRole) map[string]string {
+	return map[string]string{
+		clusterv1.ClusterLabelName:             cluster.Name,
+		infrav1beta1.ControlPlaneRoleLabelName: string(role),
+	}
+}
+
+func tcpPorts(ports ...int) []networkingv1.NetworkPolicyPort {
+	var res []networkingv1.NetworkPolicyPort
+	for _, p := range ports {
+		protocol := v1.ProtocolTCP
+		port := intstr.FromInt(p)
+		res = append(res, networkingv1.NetworkPolicyPort{
+			Protocol: &protocol,
+			Port:     &port,
+		})
+	}
+
+	return res
+}
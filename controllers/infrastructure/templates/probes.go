@@ -0,0 +1,127 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// The probes follow the static pods of kubeadm: the components have a few minutes to start,
+// and they are restarted if they are not live for more than a minute.
+const (
+	probeInitialDelaySeconds = 10
+	probeTimeoutSeconds      = 15
+	probePeriodSeconds       = 10
+
+	livenessFailureThreshold = 8
+	startupFailureThreshold  = 24
+
+	readinessPeriodSeconds    = 1
+	readinessFailureThreshold = 3
+
+	// etcdLivenessHealthEndpoint checks the local member only, regardless of the quorum and alarms.
+	etcdLivenessHealthEndpoint = "/health?exclude=NOSPACE&serializable=true"
+)
+
+// setProbes sets the liveness, readiness and startup probes of the component container in the
+// role, against the health endpoints of the component.
+func setProbes(pod *v1.Pod, kcp *ctrlv1beta1.KinkControlPlane, role infrav1beta1.ControlPlaneRole) {
+	if len(pod.Spec.Containers) == 0 {
+		return
+	}
+
+	var liveness, readiness v1.ProbeHandler
+	switch role {
+	case infrav1beta1.ApiServer:
+		if isAnonymousAuthDisabled(kcp) {
+			// The health endpoints reject the anonymous requests of the kubelet, only check
+			// that the API Server is serving.
+			liveness = tcpProbeHandler(ApiServerDefaultPort)
+			readiness = tcpProbeHandler(ApiServerDefaultPort)
+		} else {
+			liveness = httpProbeHandler("/livez", ApiServerDefaultPort, v1.URISchemeHTTPS)
+			readiness = httpProbeHandler("/readyz", ApiServerDefaultPort, v1.URISchemeHTTPS)
+		}
+	case infrav1beta1.ETCD:
+		// Do not restart etcd when the quorum is lost, it is only not ready.
+		liveness = httpProbeHandler(etcdLivenessHealthEndpoint, EtcdDefaultPort, v1.URISchemeHTTP)
+		readiness = httpProbeHandler("/health", EtcdDefaultPort, v1.URISchemeHTTP)
+	case infrav1beta1.ControllerManager:
+		liveness = httpProbeHandler("/healthz", controllerManagerSecurePort, v1.URISchemeHTTPS)
+		readiness = liveness
+	case infrav1beta1.Scheduler:
+		liveness = httpProbeHandler("/healthz", schedulerSecurePort, v1.URISchemeHTTPS)
+		readiness = liveness
+	default:
+		return
+	}
+
+	c := &pod.Spec.Containers[0]
+	c.LivenessProbe = &v1.Probe{
+		ProbeHandler:        liveness,
+		InitialDelaySeconds: probeInitialDelaySeconds,
+		TimeoutSeconds:      probeTimeoutSeconds,
+		PeriodSeconds:       probePeriodSeconds,
+		FailureThreshold:    livenessFailureThreshold,
+	}
+	c.ReadinessProbe = &v1.Probe{
+		ProbeHandler:     readiness,
+		TimeoutSeconds:   probeTimeoutSeconds,
+		PeriodSeconds:    readinessPeriodSeconds,
+		FailureThreshold: readinessFailureThreshold,
+	}
+	c.StartupProbe = &v1.Probe{
+		ProbeHandler:        liveness,
+		InitialDelaySeconds: probeInitialDelaySeconds,
+		TimeoutSeconds:      probeTimeoutSeconds,
+		PeriodSeconds:       probePeriodSeconds,
+		FailureThreshold:    startupFailureThreshold,
+	}
+}
+
+// isAnonymousAuthDisabled returns true if the API Server rejects the anonymous requests.
+func isAnonymousAuthDisabled(kcp *ctrlv1beta1.KinkControlPlane) bool {
+	if component := kcp.Spec.APIServer; component != nil {
+		if value, found := component.ExtraArgs["anonymous-auth"]; found {
+			return value == "false"
+		}
+	}
+
+	return kcp.Spec.SecurityProfile == ctrlv1beta1.CISSecurityProfile
+}
+
+func httpProbeHandler(path string, port int, scheme v1.URIScheme) v1.ProbeHandler {
+	return v1.ProbeHandler{
+		HTTPGet: &v1.HTTPGetAction{
+			Path:   path,
+			Port:   intstr.FromInt(port),
+			Scheme: scheme,
+		},
+	}
+}
+
+func tcpProbeHandler(port int) v1.ProbeHandler {
+	return v1.ProbeHandler{
+		TCPSocket: &v1.TCPSocketAction{
+			Port: intstr.FromInt(port),
+		},
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	apiserverv1 "k8s.io/apiserver/pkg/apis/apiserver/v1"
+	eventratelimitv1alpha1 "k8s.io/kubernetes/plugin/pkg/admission/eventratelimit/apis/eventratelimit/v1alpha1"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+const (
+	// AdmissionConfigDataName is the key of the admission configuration in its ConfigMap.
+	AdmissionConfigDataName = "admission.yaml"
+
+	admissionConfigVolumeName = "admission-config"
+	admissionConfigDir        = "/etc/kubernetes/admission"
+
+	// defaultKubernetesVersion is the version of the images of the control plane components,
+	// which is assumed when the KinkMachine has no valid version.
+	defaultKubernetesVersion = "v1.24.1"
+
+	// componentImageRepository is the repository of the images of the control plane components.
+	componentImageRepository = "openbce"
+)
+
+var (
+	// The PodSecurity admission plugin is enabled by default since v1.23.
+	podSecurityPluginVersion = version.MustParseGeneric("v1.23.0")
+	// The insecure port of the API Server was removed in v1.24, together with its flag.
+	insecurePortRemovedVersion = version.MustParseGeneric("v1.24.0")
+	// KMS v2 is behind the KMSv2 feature gate of the API Server before v1.27.
+	kmsV2GAVersion = version.MustParseGeneric("v1.27.0")
+)
+
+// strongCipherSuites are the TLS 1.2 cipher suites with forward secrecy and AEAD; the cipher
+// suites of TLS 1.3 are not configurable.
+var strongCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+}
+
+// AdmissionConfigConfigMapName is the name of the ConfigMap with the admission configuration
+// generated from the SecurityProfile of KinkControlPlane.
+func AdmissionConfigConfigMapName(cluster *clusterv1.Cluster) string {
+	return cluster.Name + "-admission"
+}
+
+// BuildAdmissionConfig returns the admission configuration of the plugins enabled by the
+// SecurityProfile of KinkControlPlane in YAML, or nil if there is none.
+func BuildAdmissionConfig(kcp *ctrlv1beta1.KinkControlPlane) ([]byte, error) {
+	if kcp.Spec.SecurityProfile != ctrlv1beta1.CISSecurityProfile {
+		return nil, nil
+	}
+
+	limits := &eventratelimitv1alpha1.Configuration{
+		Limits: []eventratelimitv1alpha1.Limit{
+			{Type: eventratelimitv1alpha1.ServerLimitType, QPS: 50, Burst: 100},
+			{Type: eventratelimitv1alpha1.NamespaceLimitType, QPS: 10, Burst: 50, CacheSize: 2000},
+		},
+	}
+	limits.APIVersion = eventratelimitv1alpha1.SchemeGroupVersion.String()
+	limits.Kind = "Configuration"
+
+	eventRateLimit, err := json.Marshal(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enforce the baseline Pod Security Standard out of kube-system, and warn about the pods
+	// violating the restricted one. The configuration is also valid for the versions without
+	// PodSecurity plugin, as it is only read by the enabled plugins.
+	podSecurity, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "pod-security.admission.config.k8s.io/v1beta1",
+		"kind":       "PodSecurityConfiguration",
+		"defaults": map[string]string{
+			"enforce":         "baseline",
+			"enforce-version": "latest",
+			"audit":           "restricted",
+			"audit-version":   "latest",
+			"warn":            "restricted",
+			"warn-version":    "latest",
+		},
+		"exemptions": map[string][]string{
+			"namespaces": {"kube-system"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config := &apiserverv1.AdmissionConfiguration{
+		Plugins: []apiserverv1.AdmissionPluginConfiguration{
+			{Name: "EventRateLimit", Configuration: &runtime.Unknown{Raw: eventRateLimit}},
+			{Name: "PodSecurity", Configuration: &runtime.Unknown{Raw: podSecurity}},
+		},
+	}
+	config.APIVersion = apiserverv1.SchemeGroupVersion.String()
+	config.Kind = "AdmissionConfiguration"
+
+	return yaml.Marshal(config)
+}
+
+// GetKubernetesVersion returns the Kubernetes version of the KinkMachine, which its control plane
+// pods run.
+func GetKubernetesVersion(machine *infrav1beta1.KinkMachine) *version.Version {
+	if machine.Spec.Version != nil {
+		if v, err := version.ParseGeneric(*machine.Spec.Version); err == nil {
+			return v
+		}
+	}
+
+	return version.MustParseGeneric(defaultKubernetesVersion)
+}
+
+// getComponentImage returns the image of the control plane component for the Kubernetes version of
+// the KinkMachine, so the flags gated by the version match the binary that is run.
+func getComponentImage(component string, machine *infrav1beta1.KinkMachine) string {
+	return fmt.Sprintf("%s/%s:v%s", componentImageRepository, component, GetKubernetesVersion(machine))
+}
+
+// getSecurityProfileArgs returns the flags of the SecurityProfile of KinkControlPlane, which are
+// shared by the API Server, the controller manager and the scheduler.
+func getSecurityProfileArgs(kcp *ctrlv1beta1.KinkControlPlane) []string {
+	switch kcp.Spec.SecurityProfile {
+	case ctrlv1beta1.BaselineSecurityProfile, ctrlv1beta1.CISSecurityProfile:
+		return []string{
+			"--profiling=false",
+			"--tls-min-version=VersionTLS12",
+			fmt.Sprintf("--tls-cipher-suites=%s", strings.Join(strongCipherSuites, ",")),
+		}
+	}
+
+	return nil
+}
+
+// getAdmissionPlugins returns the admission plugins enabled by the SecurityProfile of
+// KinkControlPlane, in addition to NodeRestriction.
+func getAdmissionPlugins(kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) []string {
+	plugins := []string{"NodeRestriction"}
+	if kcp.Spec.SecurityProfile != ctrlv1beta1.CISSecurityProfile {
+		return plugins
+	}
+
+	plugins = append(plugins, "EventRateLimit")
+	if GetKubernetesVersion(machine).AtLeast(podSecurityPluginVersion) {
+		plugins = append(plugins, "PodSecurity")
+	}
+
+	return plugins
+}
+
+// getAPIServerProfileArgs returns the flags of the SecurityProfile of KinkControlPlane that are
+// only for the API Server, and the volumes they need.
+func getAPIServerProfileArgs(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane,
+	machine *infrav1beta1.KinkMachine) ([]string, []v1.Volume, []v1.VolumeMount) {
+	if kcp.Spec.SecurityProfile != ctrlv1beta1.CISSecurityProfile {
+		return nil, nil, nil
+	}
+
+	args := []string{
+		"--anonymous-auth=false",
+		// The extraArgs of the API Server may override it for the kubelets with self-signed
+		// serving certificates.
+		"--kubelet-certificate-authority=/etc/kubernetes/pki/ca/tls.crt",
+		fmt.Sprintf("--admission-control-config-file=%s/%s", admissionConfigDir, AdmissionConfigDataName),
+	}
+	if GetKubernetesVersion(machine).LessThan(insecurePortRemovedVersion) {
+		args = append(args, "--insecure-port=0")
+	}
+
+	volumes := []v1.Volume{
+		{
+			Name: admissionConfigVolumeName,
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: AdmissionConfigConfigMapName(cluster)},
+					Items: []v1.KeyToPath{
+						{
+							Key:  AdmissionConfigDataName,
+							Path: AdmissionConfigDataName,
+						},
+					},
+				},
+			},
+		},
+	}
+	mounts := []v1.VolumeMount{
+		{
+			Name:      admissionConfigVolumeName,
+			MountPath: admissionConfigDir,
+			ReadOnly:  true,
+		},
+	}
+
+	return args, volumes, mounts
+}
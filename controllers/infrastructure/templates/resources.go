@@ -0,0 +1,98 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+// sizePresets are the resources of the components in each size. Only the memory is limited, so
+// the components are not throttled; they run as Burstable and are evicted after BestEffort pods.
+var sizePresets = map[ctrlv1beta1.ControlPlaneSize]map[infrav1beta1.ControlPlaneRole]v1.ResourceRequirements{
+	ctrlv1beta1.SmallControlPlane: {
+		infrav1beta1.ETCD:              presetResources("100m", "256Mi", "512Mi"),
+		infrav1beta1.ApiServer:         presetResources("250m", "512Mi", "1Gi"),
+		infrav1beta1.ControllerManager: presetResources("100m", "128Mi", "256Mi"),
+		infrav1beta1.Scheduler:         presetResources("50m", "64Mi", "128Mi"),
+	},
+	ctrlv1beta1.MediumControlPlane: {
+		infrav1beta1.ETCD:              presetResources("500m", "1Gi", "2Gi"),
+		infrav1beta1.ApiServer:         presetResources("1", "2Gi", "4Gi"),
+		infrav1beta1.ControllerManager: presetResources("250m", "512Mi", "1Gi"),
+		infrav1beta1.Scheduler:         presetResources("100m", "256Mi", "512Mi"),
+	},
+	ctrlv1beta1.LargeControlPlane: {
+		infrav1beta1.ETCD:              presetResources("2", "4Gi", "8Gi"),
+		infrav1beta1.ApiServer:         presetResources("4", "8Gi", "16Gi"),
+		infrav1beta1.ControllerManager: presetResources("1", "2Gi", "4Gi"),
+		infrav1beta1.Scheduler:         presetResources("500m", "1Gi", "2Gi"),
+	},
+}
+
+func presetResources(cpu, memory, memoryLimit string) v1.ResourceRequirements {
+	return v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(memory),
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse(memoryLimit),
+		},
+	}
+}
+
+// setResources sets the resources of the component container in the role from the Size preset
+// and the Resources of the component, and the PriorityClass of the pod.
+func setResources(pod *v1.Pod, kcp *ctrlv1beta1.KinkControlPlane, role infrav1beta1.ControlPlaneRole) {
+	pod.Spec.PriorityClassName = kcp.Spec.PriorityClassName
+
+	if len(pod.Spec.Containers) == 0 {
+		return
+	}
+
+	size := kcp.Spec.Size
+	if len(size) == 0 {
+		size = ctrlv1beta1.SmallControlPlane
+	}
+
+	preset := sizePresets[size][role]
+	resources := v1.ResourceRequirements{
+		Requests: v1.ResourceList{},
+		Limits:   v1.ResourceList{},
+	}
+	for name, quantity := range preset.Requests {
+		resources.Requests[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range preset.Limits {
+		resources.Limits[name] = quantity.DeepCopy()
+	}
+
+	if component := getComponent(kcp, role); component != nil && component.Resources != nil {
+		for name, quantity := range component.Resources.Requests {
+			resources.Requests[name] = quantity.DeepCopy()
+		}
+		for name, quantity := range component.Resources.Limits {
+			resources.Limits[name] = quantity.DeepCopy()
+		}
+	}
+
+	pod.Spec.Containers[0].Resources = resources
+}
@@ -26,75 +26,61 @@ import (
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
 	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
 )
 
-func SchedulerPodTemplate(cluster *clusterv1.Cluster, machine *infrav1beta1.KinkMachine) *v1.Pod {
+func SchedulerPodTemplate(cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, machine *infrav1beta1.KinkMachine) *v1.Pod {
 	owner := metav1.NewControllerRef(machine,
 		infrav1beta1.GroupVersion.WithKind("KinkMachine"))
 
-	volumes, mounts := getSecretVolumes(cluster)
+	volumes, mounts := getSecretVolumes(cluster, infrav1beta1.Scheduler)
 
-	serviceDIDR := "192.168.0.0/24"
-	if len(cluster.Spec.ClusterNetwork.Services.CIDRBlocks) != 0 {
-		serviceDIDR = cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0]
+	kubeconfig := getKubeconfigPath(secrets.SchedulerName)
+
+	args := []string{
+		"kube-scheduler",
+		fmt.Sprintf("--authentication-kubeconfig=%s", kubeconfig),
+		fmt.Sprintf("--authorization-kubeconfig=%s", kubeconfig),
+		"--bind-address=${host_ip}",
+		fmt.Sprintf("--kubeconfig=%s", kubeconfig),
+		"--leader-elect=true",
 	}
+	args = append(args, getSecurityProfileArgs(kcp)...)
+	args = getComponentArgs(kcp, infrav1beta1.Scheduler, args)
 
-	return &v1.Pod{
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      names.SimpleNameGenerator.GenerateName(cluster.Name + "-apiserver-"),
+			Name:      names.SimpleNameGenerator.GenerateName(cluster.Name + "-scheduler-"),
 			Namespace: cluster.Namespace,
 			Labels: map[string]string{
 				clusterv1.ClusterLabelName:             cluster.Name,
-				infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.ApiServer),
+				infrav1beta1.ControlPlaneRoleLabelName: string(infrav1beta1.Scheduler),
 			},
 			OwnerReferences: []metav1.OwnerReference{*owner},
 		},
 		Spec: v1.PodSpec{
 			RestartPolicy: v1.RestartPolicyAlways,
-			HostNetwork:   true,
-			DNSPolicy:     v1.DNSClusterFirstWithHostNet,
 			Containers: []v1.Container{
 				{
-					Name:    "apiserver",
-					Image:   "openbce/kube-scheduler:v1.24.1",
-					Env:     []v1.EnvVar{hostIPEnvVar},
-					Command: []string{"/bin/sh", "-c"},
-					Args: []string{strings.Join([]string{
-						"kube-apiserver",
-						"--advertise-address=${host_ip}",
-						"--secure-port=6443",
-						fmt.Sprintf("--etcd-servers=http://%s-etcd-svc.%s:%d",
-							cluster.Name, cluster.Namespace, EtcdDefaultPort),
-						fmt.Sprintf("--service-cluster-ip-range=%s", serviceDIDR),
-
-						"--allow-privileged=true",
-						"--authorization-mode=Node,RBAC",
-						"--enable-admission-plugins=NodeRestriction",
-						"--enable-bootstrap-token-auth=true",
-						"--kubelet-preferred-address-types=InternalIP,ExternalIP,Hostname",
-						"--requestheader-allowed-names=front-proxy-client",
-						"--requestheader-extra-headers-prefix=X-Remote-Extra-",
-						"--requestheader-group-headers=X-Remote-Group",
-						"--requestheader-username-headers=X-Remote-User",
-
-						"--client-ca-file=/etc/kubernetes/pki/ca/tls.crt",
-						"--requestheader-client-ca-file=/etc/kubernetes/pki/ca/tls.crt",
-						"--proxy-client-cert-file=/etc/kubernetes/pki/front-proxy-ca/tls.crt",
-						"--proxy-client-key-file=/etc/kubernetes/pki/front-proxy-ca/tls.key",
-						"--kubelet-client-certificate=/etc/kubernetes/pki/kubelet-client/tls.crt",
-						"--kubelet-client-key=/etc/kubernetes/pki/kubelet-client/tls.key",
-						"--service-account-issuer=https://kubernetes.default.svc.cluster.local",
-						"--service-account-key-file=/etc/kubernetes/pki/sa/tls.crt",
-						"--service-account-signing-key-file=/etc/kubernetes/pki/sa/tls.key",
-						"--tls-cert-file=/etc/kubernetes/pki/apiserver/tls.crt",
-						"--tls-private-key-file=/etc/kubernetes/pki/apiserver/tls.key"},
-						" "),
-					},
+					Name:         "scheduler",
+					Image:        getComponentImage("kube-scheduler", machine),
+					Env:          []v1.EnvVar{hostIPEnvVar},
+					Command:      []string{"/bin/sh", "-c"},
+					Args:         []string{strings.Join(args, " ")},
 					VolumeMounts: mounts,
 				},
 			},
 			Volumes: volumes,
 		},
 	}
+
+	setScheduling(pod, cluster, kcp, infrav1beta1.Scheduler)
+	setResources(pod, kcp, infrav1beta1.Scheduler)
+	setProbes(pod, kcp, infrav1beta1.Scheduler)
+	setComponent(pod, kcp, infrav1beta1.Scheduler)
+	setPodSecurity(pod, kcp)
+
+	return pod
 }
@@ -0,0 +1,85 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+)
+
+const (
+	// The weights of the default anti-affinity, spreading the replicas across the nodes first.
+	hostAntiAffinityWeight = 100
+	zoneAntiAffinityWeight = 50
+)
+
+// setScheduling places the control plane pod in the role according to the Scheduling of
+// KinkControlPlane; the pods of the same role in the cluster prefer different nodes and zones
+// unless PodAntiAffinity is set.
+func setScheduling(pod *v1.Pod, cluster *clusterv1.Cluster, kcp *ctrlv1beta1.KinkControlPlane, role infrav1beta1.ControlPlaneRole) {
+	scheduling := kcp.Spec.Scheduling
+	if scheduling == nil {
+		scheduling = &ctrlv1beta1.SchedulingSpec{}
+	}
+
+	selector := &metav1.LabelSelector{
+		MatchLabels: roleLabels(cluster, role),
+	}
+
+	pod.Spec.NodeSelector = scheduling.NodeSelector
+	pod.Spec.Tolerations = scheduling.Tolerations
+
+	affinity := &v1.Affinity{}
+	if scheduling.Affinity != nil {
+		affinity = scheduling.Affinity.DeepCopy()
+	}
+	if affinity.PodAntiAffinity == nil {
+		affinity.PodAntiAffinity = &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+				{
+					Weight: hostAntiAffinityWeight,
+					PodAffinityTerm: v1.PodAffinityTerm{
+						LabelSelector: selector,
+						TopologyKey:   v1.LabelHostname,
+					},
+				},
+				{
+					Weight: zoneAntiAffinityWeight,
+					PodAffinityTerm: v1.PodAffinityTerm{
+						LabelSelector: selector,
+						TopologyKey:   v1.LabelTopologyZone,
+					},
+				},
+			},
+		}
+	}
+	pod.Spec.Affinity = affinity
+
+	pod.Spec.TopologySpreadConstraints = nil
+	for _, c := range scheduling.TopologySpreadConstraints {
+		c := *c.DeepCopy()
+		if c.LabelSelector == nil {
+			c.LabelSelector = selector
+		}
+		pod.Spec.TopologySpreadConstraints = append(pod.Spec.TopologySpreadConstraints, c)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+)
+
+const (
+	// defaultRunAsID is the "nonroot" user and group of the distroless images.
+	defaultRunAsID int64 = 65532
+
+	// secretFileMode only allows the owner and the group of the volumes to read the secrets.
+	secretFileMode int32 = 0440
+
+	tmpVolumeName = "tmp"
+	tmpDir        = "/tmp"
+)
+
+// isPrivileged returns true if the control plane pods opt out of the hardening.
+func isPrivileged(kcp *ctrlv1beta1.KinkControlPlane) bool {
+	return kcp.Spec.PodSecurity != nil && kcp.Spec.PodSecurity.Privileged
+}
+
+// isHostNetwork returns true if the hardened control plane pods are kept on the host network,
+// see HostNetworkAnnotationName.
+func isHostNetwork(kcp *ctrlv1beta1.KinkControlPlane) bool {
+	return kcp.Annotations[ctrlv1beta1.HostNetworkAnnotationName] == "true"
+}
+
+// setPodSecurity hardens the control plane pod to pass the restricted Pod Security Standard: it
+// runs on the pod network as a non-root user without any capabilities, with a read-only root
+// filesystem and the RuntimeDefault seccomp profile, and the secrets are only readable by its
+// group. The privileged pods run on the host network as they are; the hardened pods also run
+// there if the KinkControlPlane is annotated so.
+func setPodSecurity(pod *v1.Pod, kcp *ctrlv1beta1.KinkControlPlane) {
+	if isPrivileged(kcp) {
+		pod.Spec.HostNetwork = true
+		pod.Spec.DNSPolicy = v1.DNSClusterFirstWithHostNet
+		return
+	}
+
+	runAsUser, runAsGroup := defaultRunAsID, defaultRunAsID
+	if ps := kcp.Spec.PodSecurity; ps != nil {
+		if ps.RunAsUser != nil {
+			runAsUser = *ps.RunAsUser
+		}
+		if ps.RunAsGroup != nil {
+			runAsGroup = *ps.RunAsGroup
+		}
+	}
+
+	if isHostNetwork(kcp) {
+		pod.Spec.HostNetwork = true
+		pod.Spec.DNSPolicy = v1.DNSClusterFirstWithHostNet
+	} else {
+		pod.Spec.HostNetwork = false
+		pod.Spec.DNSPolicy = v1.DNSClusterFirst
+	}
+	pod.Spec.SecurityContext = &v1.PodSecurityContext{
+		RunAsNonRoot: pointer.Bool(true),
+		RunAsUser:    pointer.Int64(runAsUser),
+		RunAsGroup:   pointer.Int64(runAsGroup),
+		FSGroup:      pointer.Int64(runAsGroup),
+		SeccompProfile: &v1.SeccompProfile{
+			Type: v1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+
+	for i := range pod.Spec.Volumes {
+		if s := pod.Spec.Volumes[i].Secret; s != nil {
+			s.DefaultMode = pointer.Int32(secretFileMode)
+		}
+	}
+
+	// The root filesystem is read-only, the components write their temporary files to an emptyDir.
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: tmpVolumeName,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		},
+	})
+
+	containers := make([]*v1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for i := range pod.Spec.InitContainers {
+		containers = append(containers, &pod.Spec.InitContainers[i])
+	}
+	for i := range pod.Spec.Containers {
+		containers = append(containers, &pod.Spec.Containers[i])
+	}
+
+	for _, c := range containers {
+		c.SecurityContext = &v1.SecurityContext{
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			ReadOnlyRootFilesystem:   pointer.Bool(true),
+			RunAsNonRoot:             pointer.Bool(true),
+			Capabilities: &v1.Capabilities{
+				Drop: []v1.Capability{"ALL"},
+			},
+		}
+		c.VolumeMounts = append(append([]v1.VolumeMount{}, c.VolumeMounts...), v1.VolumeMount{
+			Name:      tmpVolumeName,
+			MountPath: tmpDir,
+		})
+	}
+}
@@ -18,11 +18,19 @@ package templates
 
 import (
 	"fmt"
+	"hash/fnv"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	hashutil "k8s.io/kubernetes/pkg/util/hash"
 	"sigs.k8s.io/cluster-api/util/secret"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
 )
 
 var hostIPEnvVar = v1.EnvVar{
@@ -34,46 +42,120 @@ var hostIPEnvVar = v1.EnvVar{
 	},
 }
 
-var certs = []string{
-	"ca",
-	"apiserver",
-	"kubelet-client",
-	"front-proxy-ca",
-	"front-proxy-client",
-	"sa",
+// pkiFiles are the files of a Secret mounted into a component, at
+// /etc/kubernetes/pki/<name>/<file> for the certs and keys, or at
+// /etc/kubernetes/kubeconfig/<name>.conf for the kubeconfigs.
+type pkiFiles struct {
+	name       string
+	files      []string
+	kubeconfig bool
 }
 
-type certPath struct {
-	crt string
-	key string
+// kubeconfigDir is where the kubeconfigs of the components are mounted.
+const kubeconfigDir = "/etc/kubernetes/kubeconfig"
+
+// rolePKIFiles are exactly the files that each component needs; in particular the private keys
+// of the CAs are only mounted into the controller manager that signs the certs of the cluster.
+var rolePKIFiles = map[infrav1beta1.ControlPlaneRole][]pkiFiles{
+	infrav1beta1.ApiServer: {
+		{name: "ca", files: []string{secret.TLSCrtDataName}},
+		{name: "apiserver", files: []string{secret.TLSCrtDataName, secret.TLSKeyDataName}},
+		{name: "kubelet-client", files: []string{secret.TLSCrtDataName, secret.TLSKeyDataName}},
+		{name: "front-proxy-ca", files: []string{secret.TLSCrtDataName}},
+		{name: "front-proxy-client", files: []string{secret.TLSCrtDataName, secret.TLSKeyDataName}},
+		{name: "sa", files: []string{secrets.SAPublicKeysDataName, secret.TLSKeyDataName}},
+	},
+	infrav1beta1.ControllerManager: {
+		{name: "ca", files: []string{secret.TLSCrtDataName, secret.TLSKeyDataName}},
+		{name: "front-proxy-ca", files: []string{secret.TLSCrtDataName}},
+		{name: "sa", files: []string{secret.TLSKeyDataName}},
+		{name: secrets.ControllerManagerName, kubeconfig: true},
+	},
+	infrav1beta1.Scheduler: {
+		{name: secrets.SchedulerName, kubeconfig: true},
+	},
 }
 
-var certPaths = map[string]certPath{}
-
-func getSecretVolumes(cluster *clusterv1.Cluster) ([]v1.Volume, []v1.VolumeMount) {
+// getSecretVolumes returns the volumes of the certs, keys and kubeconfigs of the component.
+func getSecretVolumes(cluster *clusterv1.Cluster, role infrav1beta1.ControlPlaneRole) ([]v1.Volume, []v1.VolumeMount) {
 	var volumes []v1.Volume
 	var mounts []v1.VolumeMount
 
-	// Add certs
-	for _, cert := range certs {
-		certName := fmt.Sprintf("%s-%s", cluster.Name, cert)
+	for _, pki := range rolePKIFiles[role] {
+		secretName := fmt.Sprintf("%s-%s", cluster.Name, pki.name)
+		mountPath := secret.DefaultCertificatesDir + "/" + pki.name
+
+		var items []v1.KeyToPath
+		for _, f := range pki.files {
+			items = append(items, v1.KeyToPath{Key: f, Path: f})
+		}
+
+		volumeName := secretName
+		if pki.kubeconfig {
+			secretName = secrets.KubeconfigSecretName(cluster.Name, pki.name)
+			volumeName = pki.name + "-kubeconfig"
+			mountPath = kubeconfigDir + "/" + pki.name
+			items = []v1.KeyToPath{{Key: secret.KubeconfigDataName, Path: pki.name + ".conf"}}
+		}
 
-		volume := v1.Volume{
-			Name: certName,
+		volumes = append(volumes, v1.Volume{
+			Name: volumeName,
 			VolumeSource: v1.VolumeSource{
 				Secret: &v1.SecretVolumeSource{
-					SecretName: certName,
+					SecretName: secretName,
+					Items:      items,
 				},
 			},
-		}
-		volumes = append(volumes, volume)
+		})
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      volumeName,
+			MountPath: mountPath,
+			ReadOnly:  true,
+		})
+	}
 
-		mount := v1.VolumeMount{
-			Name:      certName,
-			MountPath: secret.DefaultCertificatesDir + "/" + string(cert),
+	return volumes, mounts
+}
+
+// getKubeconfigPath returns the path of the kubeconfig of the component.
+func getKubeconfigPath(name string) string {
+	return fmt.Sprintf("%s/%s/%s.conf", kubeconfigDir, name, name)
+}
+
+// ComputeHash returns the hash of the pod template; the generated name is excluded, so the hash
+// only changes when the template itself was changed.
+func ComputeHash(pod *v1.Pod) string {
+	hasher := fnv.New32a()
+	hashutil.DeepHashObject(hasher, struct {
+		Labels      map[string]string
+		Annotations map[string]string
+		Spec        v1.PodSpec
+	}{
+		Labels:      pod.Labels,
+		Annotations: pod.Annotations,
+		Spec:        pod.Spec,
+	})
+
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// getSAKeyIDs returns the IDs of the service account keys in the verification set.
+func getSAKeyIDs(kcp *ctrlv1beta1.KinkControlPlane) string {
+	var ids []string
+	for _, k := range kcp.Status.ServiceAccountKeys {
+		ids = append(ids, k.KeyID)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+// getSASigningKeyID returns the ID of the active service account key, which signs the tokens.
+func getSASigningKeyID(kcp *ctrlv1beta1.KinkControlPlane) string {
+	for _, k := range kcp.Status.ServiceAccountKeys {
+		if k.RetiredAt == nil && !k.Pending {
+			return k.KeyID
 		}
-		mounts = append(mounts, mount)
 	}
 
-	return volumes, mounts
+	return ""
 }
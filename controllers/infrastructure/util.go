@@ -19,11 +19,43 @@ package infrastructure
 import (
 	"fmt"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
 	"openbce.io/kink/apis/infrastructure/v1beta1"
 )
 
+// roleDependencies are the roles that must be ready before the pod of a role is created or
+// rolled: the API Server stores the resources in etcd, and the controller manager and the
+// scheduler talk to the API Server.
+var roleDependencies = map[v1beta1.ControlPlaneRole][]v1beta1.ControlPlaneRole{
+	v1beta1.ApiServer:         {v1beta1.ETCD},
+	v1beta1.ControllerManager: {v1beta1.ApiServer},
+	v1beta1.Scheduler:         {v1beta1.ApiServer},
+}
+
+// roleConditions are the conditions of KinkMachine reporting the pod of each role.
+var roleConditions = map[v1beta1.ControlPlaneRole]clusterv1.ConditionType{
+	v1beta1.ETCD:              v1beta1.EtcdReadyCondition,
+	v1beta1.ApiServer:         v1beta1.APIServerReadyCondition,
+	v1beta1.ControllerManager: v1beta1.ControllerManagerReadyCondition,
+	v1beta1.Scheduler:         v1beta1.SchedulerReadyCondition,
+}
+
+// getPendingDependencies returns the dependencies of the role that are not ready yet.
+func getPendingDependencies(role v1beta1.ControlPlaneRole, readyRoles map[v1beta1.ControlPlaneRole]bool) []string {
+	var pending []string
+	for _, dep := range roleDependencies[role] {
+		if !readyRoles[dep] {
+			pending = append(pending, string(dep))
+		}
+	}
+
+	return pending
+}
+
 func getControlPlaneRole(pod *metav1.ObjectMeta) (v1beta1.ControlPlaneRole, error) {
 	if pod == nil || pod.Labels == nil {
 		return v1beta1.Unkonwn, fmt.Errorf("pod is nil")
@@ -36,3 +68,18 @@ func getControlPlaneRole(pod *metav1.ObjectMeta) (v1beta1.ControlPlaneRole, erro
 
 	return v1beta1.ControlPlaneRole(podType), nil
 }
+
+// isPodContainersReady returns true if the pod is running and all its containers are ready.
+func isPodContainersReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if !status.Ready {
+			return false
+		}
+	}
+
+	return true
+}
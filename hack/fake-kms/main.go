@@ -0,0 +1,156 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// fake-kms is a software KMS plugin for exercising the kms provider of the encryption at rest
+// without an external KMS. It serves the KMS v1 API on a Unix socket, and encrypts the data
+// encryption keys of API Server by AES-GCM with a local key encryption key. The key encryption
+// key is read from --key-file, which holds 32 base64 encoded bytes; a random key is used if it
+// is not set, so the encrypted resources can not be read after the plugin is restarted.
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/v1beta1"
+)
+
+const (
+	kmsAPIVersion = "v1beta1"
+	runtimeName   = "fake-kms"
+	keySize       = 32
+)
+
+type kmsServer struct {
+	aead cipher.AEAD
+}
+
+func main() {
+	var listen, keyFile string
+	flag.StringVar(&listen, "listen", "unix://"+os.Getenv("KMS_SOCKET_PATH"), "The Unix socket to listen on.")
+	flag.StringVar(&keyFile, "key-file", "", "The file of the base64 encoded key encryption key.")
+	flag.Parse()
+
+	key, err := loadKey(keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load the key encryption key: %v", err)
+	}
+
+	server, err := newServer(key)
+	if err != nil {
+		log.Fatalf("Failed to create the cipher: %v", err)
+	}
+
+	socket := strings.TrimPrefix(listen, "unix://")
+	if len(socket) == 0 {
+		log.Fatal("The Unix socket to listen on is not set")
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to remove the stale socket %s: %v", socket, err)
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	log.Printf("Serving the KMS plugin at %s", socket)
+	log.Fatal(server.Serve(l))
+}
+
+// newServer returns the gRPC server of the KMS v1 API, which encrypts by the key encryption key.
+func newServer(key []byte) (*grpc.Server, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	server := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(server, &kmsServer{aead: aead})
+
+	return server, nil
+}
+
+func loadKey(keyFile string) ([]byte, error) {
+	if len(keyFile) == 0 {
+		log.Print("No key file is set, a random key encryption key is used")
+		key := make([]byte, keySize)
+		_, err := rand.Read(key)
+		return key, err
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("the key encryption key has %d bytes, expected %d", len(key), keySize)
+	}
+
+	return key, nil
+}
+
+func (s *kmsServer) Version(ctx context.Context, req *kmsapi.VersionRequest) (*kmsapi.VersionResponse, error) {
+	return &kmsapi.VersionResponse{
+		Version:        kmsAPIVersion,
+		RuntimeName:    runtimeName,
+		RuntimeVersion: kmsAPIVersion,
+	}, nil
+}
+
+func (s *kmsServer) Encrypt(ctx context.Context, req *kmsapi.EncryptRequest) (*kmsapi.EncryptResponse, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &kmsapi.EncryptResponse{
+		Cipher: s.aead.Seal(nonce, nonce, req.Plain, nil),
+	}, nil
+}
+
+func (s *kmsServer) Decrypt(ctx context.Context, req *kmsapi.DecryptRequest) (*kmsapi.DecryptResponse, error) {
+	size := s.aead.NonceSize()
+	if len(req.Cipher) < size {
+		return nil, fmt.Errorf("the cipher is too short")
+	}
+
+	plain, err := s.aead.Open(nil, req.Cipher[:size], req.Cipher[size:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kmsapi.DecryptResponse{Plain: plain}, nil
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/server/options/encryptionconfig"
+	"k8s.io/apiserver/pkg/storage/value"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/controlplane/secrets"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// TestEncryptionConfiguration encrypts a Secret by the EncryptionConfiguration that kink generates
+// for the kms provider, through the KMS plugin of the API Server pod served by fake-kms.
+func TestEncryptionConfiguration(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: clusterv1.ClusterSpec{
+			ClusterNetwork: &clusterv1.ClusterNetwork{
+				Services: &clusterv1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+			},
+		},
+	}
+	kcp := &ctrlv1beta1.KinkControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "kcp"},
+		Spec: ctrlv1beta1.KinkControlPlaneSpec{
+			Version: pointer.String("v1.24.1"),
+			Encryption: &ctrlv1beta1.EncryptionSpec{
+				Provider: ctrlv1beta1.KMSEncryption,
+				KMS: &ctrlv1beta1.KMSPlugin{
+					Name:  "fake-kms",
+					Image: "openbce/fake-kms",
+					Args:  []string{"--listen=unix://$(KMS_SOCKET_PATH)"},
+				},
+			},
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	// Introduce the key, and activate it once the API Servers are assumed to have loaded it.
+	certs := secrets.NewCertificatesManager(ctx, c, cluster, kcp)
+	observed := func(*ctrlv1beta1.EncryptionStatus) (bool, error) { return true, nil }
+	reencrypt := func([]string) error { return nil }
+	for i := 0; i < 2; i++ {
+		status, _, err := certs.LookupOrRotateEncryptionKeys(observed, reencrypt)
+		if err != nil {
+			t.Fatalf("failed to rotate the encryption keys: %v", err)
+		}
+		kcp.Status.Encryption = status
+	}
+	if keys := kcp.Status.Encryption.Keys; len(keys) == 0 || keys[0].Provider != ctrlv1beta1.KMSEncryption ||
+		keys[0].State != ctrlv1beta1.EncryptionKeyActive {
+		t.Fatalf("expected an active key of the kms provider, got %+v", keys)
+	}
+
+	// The API Server reads the configuration from the encryption Secret, and reaches the KMS
+	// plugin through the socket in the volume shared with the sidecar container.
+	machine := &infrav1beta1.KinkMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default"},
+		Spec:       infrav1beta1.KinkMachineSpec{Version: kcp.Spec.Version},
+	}
+	pod := templates.ApiServerPodTemplate(cluster, kcp, machine)
+
+	var apiServer, plugin *v1.Container
+	for i := range pod.Spec.Containers {
+		switch pod.Spec.Containers[i].Name {
+		case "apiserver":
+			apiServer = &pod.Spec.Containers[i]
+		case "kms-plugin":
+			plugin = &pod.Spec.Containers[i]
+		}
+	}
+	if apiServer == nil || plugin == nil {
+		t.Fatalf("expected the apiserver and the kms-plugin containers, got %+v", pod.Spec.Containers)
+	}
+
+	var configFile string
+	for _, arg := range strings.Fields(apiServer.Args[0]) {
+		if strings.HasPrefix(arg, "--encryption-provider-config=") {
+			configFile = strings.TrimPrefix(arg, "--encryption-provider-config=")
+		}
+	}
+	if len(configFile) == 0 {
+		t.Fatalf("expected the --encryption-provider-config flag, got %s", apiServer.Args[0])
+	}
+
+	var socketPath string
+	for _, env := range plugin.Env {
+		if env.Name == "KMS_SOCKET_PATH" {
+			socketPath = env.Value
+		}
+	}
+	if !isMounted(apiServer, filepath.Dir(socketPath)) || !isMounted(plugin, filepath.Dir(socketPath)) {
+		t.Fatalf("expected the socket %s to be shared by the API Server and the KMS plugin", socketPath)
+	}
+
+	// Lay out the files of the pod under a temporary root.
+	root := t.TempDir()
+	sec := &v1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: secrets.EncryptionSecretName(cluster)}, sec); err != nil {
+		t.Fatalf("failed to get the encryption Secret: %v", err)
+	}
+	config := bytes.ReplaceAll(sec.Data[secrets.EncryptionConfigDataName],
+		[]byte("unix://"+socketPath), []byte("unix://"+filepath.Join(root, socketPath)))
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(configFile)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, configFile), config, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	server, err := newServer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(socketPath)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(root, socketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Stop()
+
+	transformers, err := encryptionconfig.GetTransformerOverrides(filepath.Join(root, configFile))
+	if err != nil {
+		t.Fatalf("failed to load the encryption configuration: %v", err)
+	}
+	transformer, found := transformers[schema.GroupResource{Resource: "secrets"}]
+	if !found {
+		t.Fatalf("expected the secrets to be encrypted, got %v", transformers)
+	}
+
+	plain := []byte("the data of a Secret")
+	dataCtx := value.DefaultContext([]byte("/registry/secrets/default/test"))
+	encrypted, err := transformer.TransformToStorage(ctx, plain, dataCtx)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if prefix := []byte("k8s:enc:kms:v1:fake-kms:"); !bytes.HasPrefix(encrypted, prefix) {
+		t.Fatalf("expected the data to be encrypted by the kms provider, got %q", encrypted)
+	}
+
+	decrypted, _, err := transformer.TransformFromStorage(ctx, encrypted, dataCtx)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("expected %q to be decrypted, got %q", plain, decrypted)
+	}
+}
+
+// TestKMSv2Unsupported checks that no key of KMS v2 is introduced while a KinkMachine runs an API
+// Server without the KMS v2 API, which would reject the EncryptionConfiguration.
+func TestKMSv2Unsupported(t *testing.T) {
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	for _, versions := range [][2]string{{"v1.24.1", "v1.24.1"}, {"v1.25.0", "v1.24.1"}} {
+		kcp := &ctrlv1beta1.KinkControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: ctrlv1beta1.KinkControlPlaneSpec{
+				Version: pointer.String(versions[0]),
+				Encryption: &ctrlv1beta1.EncryptionSpec{
+					Provider: ctrlv1beta1.KMSEncryption,
+					KMS:      &ctrlv1beta1.KMSPlugin{Name: "fake-kms", APIVersion: ctrlv1beta1.KMSAPIVersionV2},
+				},
+			},
+			Status: ctrlv1beta1.KinkControlPlaneStatus{Version: pointer.String(versions[1])},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()
+		certs := secrets.NewCertificatesManager(context.Background(), c, cluster, kcp)
+		if _, _, err := certs.LookupOrRotateEncryptionKeys(nil, nil); err == nil {
+			t.Errorf("expected KMS v2 to be rejected for KinkControlPlane %s with KinkMachines on %s", versions[0], versions[1])
+		}
+	}
+}
+
+// isMounted returns true if the container mounts a volume at the path.
+func isMounted(c *v1.Container, path string) bool {
+	for _, m := range c.VolumeMounts {
+		if m.MountPath == path {
+			return true
+		}
+	}
+
+	return false
+}
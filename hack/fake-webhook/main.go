@@ -0,0 +1,209 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// fake-webhook is a token authentication and authorization webhook for exercising the Webhook
+// authenticator and authorizer of the tenant API Servers. The tokens are read from a CSV file in
+// the format of the static token file of kube-apiserver (token,user,uid,"group1,group2"), and the
+// requests of the allowed users or groups are authorized. The webhook fails all the reviews with
+// --fail, to check how the API Server behaves when the remote service is not available.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type tokenUser struct {
+	Username string
+	UID      string
+	Groups   []string
+}
+
+type webhook struct {
+	tokens        map[string]tokenUser
+	allowedUsers  sets.String
+	allowedGroups sets.String
+	fail          bool
+}
+
+func main() {
+	var addr, tokenFile, allowedUsers, allowedGroups, certFile, keyFile string
+	var fail bool
+	flag.StringVar(&addr, "bind-address", ":8443", "The address the webhook binds to.")
+	flag.StringVar(&tokenFile, "token-file", "", "The CSV file of the tokens: token,user,uid,\"group1,group2\".")
+	flag.StringVar(&allowedUsers, "allowed-users", "", "The comma separated users to authorize.")
+	flag.StringVar(&allowedGroups, "allowed-groups", "", "The comma separated groups to authorize.")
+	flag.StringVar(&certFile, "tls-cert-file", "", "The serving certificate; plain http is served if it is not set.")
+	flag.StringVar(&keyFile, "tls-private-key-file", "", "The key of the serving certificate.")
+	flag.BoolVar(&fail, "fail", false, "Fail all the reviews, as if the webhook is not available.")
+	flag.Parse()
+
+	w := &webhook{
+		tokens:        map[string]tokenUser{},
+		allowedUsers:  sets.NewString(splitList(allowedUsers)...),
+		allowedGroups: sets.NewString(splitList(allowedGroups)...),
+		fail:          fail,
+	}
+
+	if len(tokenFile) > 0 {
+		if err := w.loadTokens(tokenFile); err != nil {
+			log.Fatalf("Failed to load tokens from %s: %v", tokenFile, err)
+		}
+	}
+
+	log.Printf("Serving the webhook at %s", addr)
+	var err error
+	if len(certFile) > 0 {
+		err = http.ListenAndServeTLS(addr, certFile, keyFile, w.handler())
+	} else {
+		err = http.ListenAndServe(addr, w.handler())
+	}
+	log.Fatal(err)
+}
+
+func (w *webhook) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authenticate", w.authenticate)
+	mux.HandleFunc("/authorize", w.authorize)
+
+	return mux
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (w *webhook) loadTokens(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			continue
+		}
+
+		user := tokenUser{Username: record[1], UID: record[2]}
+		if len(record) > 3 {
+			user.Groups = splitList(record[3])
+		}
+		w.tokens[record[0]] = user
+	}
+}
+
+// authenticate handles TokenReview; v1 and v1beta1 share the same schema.
+func (w *webhook) authenticate(rw http.ResponseWriter, req *http.Request) {
+	if w.fail {
+		http.Error(rw, "webhook is not available", http.StatusInternalServerError)
+		return
+	}
+
+	review := &authnv1.TokenReview{}
+	if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, found := w.tokens[review.Spec.Token]
+	review.Status = authnv1.TokenReviewStatus{Authenticated: found}
+	if found {
+		review.Status.User = authnv1.UserInfo{
+			Username: user.Username,
+			UID:      user.UID,
+			Groups:   user.Groups,
+		}
+	}
+	log.Printf("TokenReview: user %q, authenticated %t", user.Username, found)
+
+	review.Spec = authnv1.TokenReviewSpec{}
+	writeJSON(rw, review)
+}
+
+// authorize handles SubjectAccessReview; the groups are named "group" in v1beta1.
+func (w *webhook) authorize(rw http.ResponseWriter, req *http.Request) {
+	if w.fail {
+		http.Error(rw, "webhook is not available", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := &authzv1.SubjectAccessReview{}
+	if err := json.Unmarshal(body, review); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groups := review.Spec.Groups
+	if review.APIVersion == authzv1beta1.SchemeGroupVersion.String() {
+		legacy := &authzv1beta1.SubjectAccessReview{}
+		if err := json.Unmarshal(body, legacy); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		groups = legacy.Spec.Groups
+	}
+
+	allowed := w.allowedUsers.Has(review.Spec.User) || w.allowedGroups.HasAny(groups...)
+	log.Printf("SubjectAccessReview: user %q, groups %v, allowed %t", review.Spec.User, groups, allowed)
+
+	writeJSON(rw, map[string]interface{}{
+		"apiVersion": review.APIVersion,
+		"kind":       review.Kind,
+		"status": authzv1.SubjectAccessReviewStatus{
+			Allowed: allowed,
+		},
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, obj interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(obj); err != nil {
+		log.Printf("Failed to write the response: %v", err)
+	}
+}
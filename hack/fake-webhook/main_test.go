@@ -0,0 +1,382 @@
+/*
+Copyright 2022 openBCE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	ctrlv1beta1 "openbce.io/kink/apis/controlplane/v1beta1"
+	infrav1beta1 "openbce.io/kink/apis/infrastructure/v1beta1"
+	"openbce.io/kink/controllers/infrastructure/templates"
+)
+
+// apiServer is the webhook configuration of the API Server pod built by kink: the flags, and
+// the files of the Secret volumes written at their mount paths under a temporary directory.
+type apiServer struct {
+	flags map[string]string
+	root  string
+}
+
+// newAPIServer builds the API Server pod of the KinkControlPlane, and writes the Secrets of the
+// webhook kubeconfigs into the volumes mounted by the pod.
+func newAPIServer(t *testing.T, kcp *ctrlv1beta1.KinkControlPlane, secrets map[string]map[string]string) *apiServer {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: clusterv1.ClusterSpec{
+			ClusterNetwork: &clusterv1.ClusterNetwork{
+				Services: &clusterv1.NetworkRanges{CIDRBlocks: []string{"10.96.0.0/12"}},
+			},
+		},
+	}
+	machine := &infrav1beta1.KinkMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-0", Namespace: "default"},
+	}
+
+	pod := templates.ApiServerPodTemplate(cluster, kcp, machine)
+	c := pod.Spec.Containers[0]
+
+	s := &apiServer{flags: map[string]string{}, root: t.TempDir()}
+	for _, arg := range strings.Fields(strings.Join(c.Args, " ")) {
+		if kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2); len(kv) == 2 && strings.HasPrefix(arg, "--") {
+			s.flags[kv[0]] = kv[1]
+		}
+	}
+
+	volumes := map[string]v1.Volume{}
+	for _, v := range pod.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+
+	for _, m := range c.VolumeMounts {
+		secret := volumes[m.Name].Secret
+		if secret == nil {
+			continue
+		}
+		data, found := secrets[secret.SecretName]
+		if !found {
+			continue
+		}
+
+		dir := filepath.Join(s.root, m.MountPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range secret.Items {
+			if err := os.WriteFile(filepath.Join(dir, item.Path), []byte(data[item.Key]), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return s
+}
+
+// kubeconfig returns the path of the kubeconfig in the flag, as mounted in the API Server pod.
+func (s *apiServer) kubeconfig(t *testing.T, flag string) string {
+	path, found := s.flags[flag]
+	if !found {
+		t.Fatalf("the flag --%s is not set", flag)
+	}
+
+	return filepath.Join(s.root, path)
+}
+
+func (s *apiServer) duration(t *testing.T, flag string, def time.Duration) time.Duration {
+	value, found := s.flags[flag]
+	if !found {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		t.Fatalf("the flag --%s is not a duration: %v", flag, err)
+	}
+
+	return d
+}
+
+func (s *apiServer) version(flag string) string {
+	if v, found := s.flags[flag]; found {
+		return v
+	}
+
+	return "v1beta1"
+}
+
+func newKubeconfig(server string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: webhook
+  cluster:
+    server: %s
+users:
+- name: apiserver
+contexts:
+- name: webhook
+  context:
+    cluster: webhook
+    user: apiserver
+current-context: webhook
+`, server)
+}
+
+func newFakeWebhook(t *testing.T, fail bool) *httptest.Server {
+	w := &webhook{
+		tokens: map[string]tokenUser{
+			"alice-token": {Username: "alice", UID: "1", Groups: []string{"dev"}},
+			"bob-token":   {Username: "bob", UID: "2", Groups: []string{"ops", "dev"}},
+			"eve-token":   {Username: "eve", UID: "3"},
+		},
+		allowedUsers:  sets.NewString("alice"),
+		allowedGroups: sets.NewString("ops"),
+		fail:          fail,
+	}
+
+	srv := httptest.NewServer(w.handler())
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newWebhookControlPlane(version ctrlv1beta1.WebhookVersion) *ctrlv1beta1.KinkControlPlane {
+	return &ctrlv1beta1.KinkControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: ctrlv1beta1.KinkControlPlaneSpec{
+			Authentication: &ctrlv1beta1.AuthenticationSpec{
+				Webhook: &ctrlv1beta1.WebhookAuthentication{
+					Kubeconfig: v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "authn-webhook"},
+						Key:                  "config",
+					},
+					CacheTTL: &metav1.Duration{Duration: time.Minute},
+					Version:  version,
+				},
+			},
+			Authorization: &ctrlv1beta1.AuthorizationSpec{
+				Webhook: &ctrlv1beta1.WebhookAuthorization{
+					Kubeconfig: v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "authz-webhook"},
+						Key:                  "config",
+					},
+					AuthorizedTTL:   &metav1.Duration{Duration: time.Minute},
+					UnauthorizedTTL: &metav1.Duration{Duration: 10 * time.Second},
+					Version:         version,
+				},
+			},
+		},
+	}
+}
+
+// webhookClient sends the reviews to the webhook in the kubeconfig of the API Server, with the
+// API version that the API Server is configured with.
+type webhookClient struct {
+	client  *http.Client
+	server  string
+	version string
+}
+
+func newWebhookClient(t *testing.T, s *apiServer, configFlag, versionFlag string) *webhookClient {
+	config, err := clientcmd.BuildConfigFromFlags("", s.kubeconfig(t, configFlag))
+	if err != nil {
+		t.Fatalf("failed to load the kubeconfig of --%s: %v", configFlag, err)
+	}
+
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		t.Fatalf("failed to create the client of --%s: %v", configFlag, err)
+	}
+
+	return &webhookClient{client: client, server: config.Host, version: s.version(versionFlag)}
+}
+
+func (c *webhookClient) post(review, result interface{}) error {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.server, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the webhook responded %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// authenticate sends a TokenReview; v1 and v1beta1 share the same schema.
+func (c *webhookClient) authenticate(token string) (authnv1.UserInfo, bool, error) {
+	review := &authnv1.TokenReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: authnv1.GroupName + "/" + c.version,
+			Kind:       "TokenReview",
+		},
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}
+
+	if err := c.post(review, review); err != nil {
+		return authnv1.UserInfo{}, false, err
+	}
+
+	return review.Status.User, review.Status.Authenticated, nil
+}
+
+// authorize sends a SubjectAccessReview to get the pods in the default namespace; the groups
+// are named "group" in v1beta1.
+func (c *webhookClient) authorize(u authnv1.UserInfo) (bool, error) {
+	meta := metav1.TypeMeta{
+		APIVersion: authzv1.GroupName + "/" + c.version,
+		Kind:       "SubjectAccessReview",
+	}
+
+	var review interface{} = &authzv1.SubjectAccessReview{
+		TypeMeta: meta,
+		Spec: authzv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authzv1.ResourceAttributes{
+				Namespace: "default",
+				Verb:      "get",
+				Version:   "v1",
+				Resource:  "pods",
+			},
+			User:   u.Username,
+			UID:    u.UID,
+			Groups: u.Groups,
+		},
+	}
+	if c.version == "v1beta1" {
+		review = &authzv1beta1.SubjectAccessReview{
+			TypeMeta: meta,
+			Spec: authzv1beta1.SubjectAccessReviewSpec{
+				ResourceAttributes: &authzv1beta1.ResourceAttributes{
+					Namespace: "default",
+					Verb:      "get",
+					Version:   "v1",
+					Resource:  "pods",
+				},
+				User:   u.Username,
+				UID:    u.UID,
+				Groups: u.Groups,
+			},
+		}
+	}
+
+	result := &authzv1.SubjectAccessReview{}
+	if err := c.post(review, result); err != nil {
+		return false, err
+	}
+
+	return result.Status.Allowed, nil
+}
+
+func TestWebhookConfig(t *testing.T) {
+	for _, version := range []ctrlv1beta1.WebhookVersion{"", "v1", "v1beta1"} {
+		t.Run(fmt.Sprintf("version=%q", version), func(t *testing.T) {
+			srv := newFakeWebhook(t, false)
+			s := newAPIServer(t, newWebhookControlPlane(version), map[string]map[string]string{
+				"authn-webhook": {"config": newKubeconfig(srv.URL + "/authenticate")},
+				"authz-webhook": {"config": newKubeconfig(srv.URL + "/authorize")},
+			})
+
+			if modes := s.flags["authorization-mode"]; modes != "Node,RBAC,Webhook" {
+				t.Errorf("expected the Webhook authorizer after Node and RBAC, got %q", modes)
+			}
+			if ttl := s.duration(t, "authentication-token-webhook-cache-ttl", 2*time.Minute); ttl != time.Minute {
+				t.Errorf("expected the cache TTL of the authentication webhook to be 1m, got %s", ttl)
+			}
+
+			authn := newWebhookClient(t, s, "authentication-token-webhook-config-file", "authentication-token-webhook-version")
+			authz := newWebhookClient(t, s, "authorization-webhook-config-file", "authorization-webhook-version")
+
+			for _, tc := range []struct {
+				token         string
+				authenticated bool
+				allowed       bool
+			}{
+				// alice is an allowed user.
+				{token: "alice-token", authenticated: true, allowed: true},
+				// bob is in an allowed group.
+				{token: "bob-token", authenticated: true, allowed: true},
+				{token: "eve-token", authenticated: true, allowed: false},
+				{token: "unknown-token", authenticated: false},
+			} {
+				u, authenticated, err := authn.authenticate(tc.token)
+				if err != nil {
+					t.Fatalf("%s: failed to authenticate: %v", tc.token, err)
+				}
+				if authenticated != tc.authenticated {
+					t.Fatalf("%s: expected authenticated %t, got %t", tc.token, tc.authenticated, authenticated)
+				}
+				if !authenticated {
+					continue
+				}
+
+				allowed, err := authz.authorize(u)
+				if err != nil {
+					t.Fatalf("%s: failed to authorize: %v", tc.token, err)
+				}
+				if allowed != tc.allowed {
+					t.Errorf("%s: expected allowed %t, got %t", tc.token, tc.allowed, allowed)
+				}
+			}
+		})
+	}
+}
+
+// TestWebhookUnavailable checks that the reviews fail, rather than being allowed, when the
+// webhook is not available.
+func TestWebhookUnavailable(t *testing.T) {
+	srv := newFakeWebhook(t, true)
+	s := newAPIServer(t, newWebhookControlPlane("v1"), map[string]map[string]string{
+		"authn-webhook": {"config": newKubeconfig(srv.URL + "/authenticate")},
+		"authz-webhook": {"config": newKubeconfig(srv.URL + "/authorize")},
+	})
+
+	authn := newWebhookClient(t, s, "authentication-token-webhook-config-file", "authentication-token-webhook-version")
+	if _, authenticated, err := authn.authenticate("alice-token"); err == nil || authenticated {
+		t.Errorf("expected the authentication to fail, got authenticated %t, err %v", authenticated, err)
+	}
+
+	authz := newWebhookClient(t, s, "authorization-webhook-config-file", "authorization-webhook-version")
+	if allowed, err := authz.authorize(authnv1.UserInfo{Username: "alice"}); err == nil || allowed {
+		t.Errorf("expected the authorization to fail, got allowed %t, err %v", allowed, err)
+	}
+}
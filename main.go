@@ -101,8 +101,9 @@ func main() {
 	}
 
 	if err = (&controlplanecontrollers.KinkControlPlaneReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("kinkcontrolplane-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KinkControlPlane")
 		os.Exit(1)